@@ -3,8 +3,12 @@ module github.com/trevor403/go-openvpn-static
 go 1.13
 
 require (
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.2.4
 	github.com/magefile/mage v1.9.0
 	github.com/mysteriumnetwork/go-ci v0.0.0-20200121125840-b99aac3d815c
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.2.2
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
+	gopkg.in/yaml.v2 v2.2.8
 )
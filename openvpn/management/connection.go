@@ -25,6 +25,8 @@ import (
 	"io"
 	"net/textproto"
 	"strings"
+	"sync"
+	"time"
 )
 
 const cmdSuccess = "SUCCESS"
@@ -32,6 +34,8 @@ const cmdError = "ERROR"
 const endOfCmdOutput = "END"
 
 type channelConnection struct {
+	// commands may be sent from several goroutines, output of one must not be read by another
+	mu        sync.Mutex
 	cmdWriter io.Writer
 	cmdOutput chan string
 }
@@ -44,8 +48,17 @@ func newChannelConnection(cmdWriter io.Writer, cmdOutput chan string) *channelCo
 }
 
 func (sc *channelConnection) SingleLineCommand(template string, args ...interface{}) (string, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
 	cmd := fmt.Sprintf(template, args...)
+	start := time.Now()
+	success, err := sc.singleLineCommand(cmd)
+	observer.CommandCompleted(commandName(cmd), time.Since(start), err)
+	return success, err
+}
 
+func (sc *channelConnection) singleLineCommand(cmd string) (string, error) {
 	_, err := fmt.Fprintf(sc.cmdWriter, "%s\n", cmd)
 	if err != nil {
 		return "", err
@@ -55,7 +68,7 @@ func (sc *channelConnection) SingleLineCommand(template string, args ...interfac
 	if !more {
 		return "", errors.New("connection is gone")
 	}
-	outputParts := strings.Split(cmdOutput, ":")
+	outputParts := strings.SplitN(cmdOutput, ":", 2)
 	messageType := textproto.TrimString(outputParts[0])
 	messageText := ""
 	if len(outputParts) > 1 {
@@ -72,7 +85,18 @@ func (sc *channelConnection) SingleLineCommand(template string, args ...interfac
 }
 
 func (sc *channelConnection) MultiLineCommand(template string, args ...interface{}) (string, []string, error) {
-	success, err := sc.SingleLineCommand(template, args...)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	cmd := fmt.Sprintf(template, args...)
+	start := time.Now()
+	success, outputLines, err := sc.multiLineCommand(cmd)
+	observer.CommandCompleted(commandName(cmd), time.Since(start), err)
+	return success, outputLines, err
+}
+
+func (sc *channelConnection) multiLineCommand(cmd string) (string, []string, error) {
+	success, err := sc.singleLineCommand(cmd)
 	if err != nil {
 		return "", nil, err
 	}
@@ -85,3 +109,42 @@ func (sc *channelConnection) MultiLineCommand(template string, args ...interface
 	}
 	return success, outputLines, nil
 }
+
+// OutputCommand sends command which replies with output lines until END marker, or with single ERROR line
+func (sc *channelConnection) OutputCommand(template string, args ...interface{}) ([]string, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	cmd := fmt.Sprintf(template, args...)
+	start := time.Now()
+	outputLines, err := sc.outputCommand(cmd)
+	observer.CommandCompleted(commandName(cmd), time.Since(start), err)
+	return outputLines, err
+}
+
+func (sc *channelConnection) outputCommand(cmd string) ([]string, error) {
+	_, err := fmt.Fprintf(sc.cmdWriter, "%s\n", cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputLines []string
+	for outputLine := range sc.cmdOutput {
+		if outputLine == endOfCmdOutput {
+			return outputLines, nil
+		}
+		if len(outputLines) == 0 && strings.HasPrefix(outputLine, cmdError+":") {
+			return nil, errors.New("command error: " + textproto.TrimString(strings.TrimPrefix(outputLine, cmdError+":")))
+		}
+		outputLines = append(outputLines, outputLine)
+	}
+	return nil, errors.New("connection is gone")
+}
+
+func commandName(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -43,3 +43,9 @@ func (conn *MockConnection) MultiLineCommand(format string, args ...interface{})
 	_, _ = conn.SingleLineCommand(format, args...)
 	return conn.CommandResult, conn.MultilineResponse, nil
 }
+
+// OutputCommand sends command to mocked connection and expects output lines with END marker without leading SUCCESS line
+func (conn *MockConnection) OutputCommand(format string, args ...interface{}) ([]string, error) {
+	_, _ = conn.SingleLineCommand(format, args...)
+	return conn.MultilineResponse, nil
+}
@@ -22,6 +22,7 @@ package management
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -38,6 +39,16 @@ func TestSingleOutputCommandHandlesSuccess(t *testing.T) {
 	assert.Equal(t, "template: 123\n", mockWriter.receivedCommand)
 }
 
+func TestSingleOutputCommandKeepsColonsInMessage(t *testing.T) {
+	outputChannel := make(chan string, 1)
+	conn := newChannelConnection(&mockWriter{}, outputChannel)
+	outputChannel <- "SUCCESS: 1 client(s) at address 10.0.0.1:1194 killed"
+
+	success, err := conn.SingleLineCommand("kill 10.0.0.1:1194")
+	assert.NoError(t, err)
+	assert.Equal(t, "1 client(s) at address 10.0.0.1:1194 killed", success)
+}
+
 func TestSingleOutputCommandHandlesFailure(t *testing.T) {
 	mockWriter := &mockWriter{}
 	outputChannel := make(chan string, 1)
@@ -88,6 +99,50 @@ func TestMultipleOutputCommandHandlesResults(t *testing.T) {
 
 }
 
+func TestOutputCommandReadsLinesWithoutSuccessLine(t *testing.T) {
+	mockWriter := &mockWriter{}
+	outputChannel := make(chan string, 10)
+	conn := newChannelConnection(mockWriter, outputChannel)
+	status := []string{
+		"TITLE\tOpenVPN 2.4.9 x86_64-pc-linux-gnu [SSL (OpenSSL)] [LZO] [LZ4] [EPOLL] [MH/PKTINFO] [AEAD]",
+		"TIME\tMon Jun  1 12:00:00 2020\t1591012800",
+		"HEADER\tCLIENT_LIST\tCommon Name\tReal Address\tVirtual Address\tVirtual IPv6 Address\tBytes Received\tBytes Sent\tConnected Since\tConnected Since (time_t)\tUsername\tClient ID\tPeer ID",
+		"CLIENT_LIST\talice\t1.2.3.4:1194\t10.8.0.6\t\t100\t200\tMon Jun  1 12:00:00 2020\t1591012800\talice\t5\t0",
+		"HEADER\tROUTING_TABLE\tVirtual Address\tCommon Name\tReal Address\tLast Ref\tLast Ref (time_t)",
+		"ROUTING_TABLE\t10.8.0.6\talice\t1.2.3.4:1194\tMon Jun  1 12:00:01 2020\t1591012801",
+		"GLOBAL_STATS\tMax bcast/mcast queue length\t0",
+	}
+	for _, line := range status {
+		outputChannel <- line
+	}
+	outputChannel <- "END"
+	outputChannel <- "SUCCESS: client-kill command succeeded"
+
+	output, err := conn.OutputCommand("status 3")
+	assert.NoError(t, err)
+	assert.Equal(t, "status 3\n", mockWriter.receivedCommand)
+	assert.Equal(t, status, output)
+
+	// following command gets its own response
+	success, err := conn.SingleLineCommand("client-kill 5")
+	assert.NoError(t, err)
+	assert.Equal(t, "client-kill command succeeded", success)
+}
+
+func TestOutputCommandHandlesError(t *testing.T) {
+	outputChannel := make(chan string, 1)
+	conn := newChannelConnection(&mockWriter{}, outputChannel)
+	outputChannel <- "ERROR: status command failed"
+
+	output, err := conn.OutputCommand("status 3")
+	assert.Nil(t, output)
+	assert.Equal(t, errors.New("command error: status command failed"), err)
+
+	close(outputChannel)
+	_, err = conn.OutputCommand("status 3")
+	assert.Error(t, err)
+}
+
 func TestClosedOutputChannelCausesCommandSendToFail(t *testing.T) {
 	mockWriter := &mockWriter{}
 	outputChannel := make(chan string, 1)
@@ -97,6 +152,33 @@ func TestClosedOutputChannelCausesCommandSendToFail(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestCommandsAreReportedToObserver(t *testing.T) {
+	obs := &recordingObserver{}
+	UseObserver(obs)
+	defer UseDefaultObserver()
+
+	outputChannel := make(chan string, 1)
+	conn := newChannelConnection(&mockWriter{}, outputChannel)
+	outputChannel <- "ERROR: unknown command"
+
+	_, err := conn.SingleLineCommand("client-kill %d %s", 3, "HALT")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"client-kill"}, obs.commands)
+	assert.Equal(t, []error{err}, obs.errors)
+}
+
+type recordingObserver struct {
+	commands []string
+	errors   []error
+}
+
+func (o *recordingObserver) CommandCompleted(command string, _ time.Duration, err error) {
+	o.commands = append(o.commands, command)
+	o.errors = append(o.errors, err)
+}
+
+func (o *recordingObserver) LineDropped(string) {}
+
 type mockWriter struct {
 	receivedCommand string
 }
@@ -215,6 +215,7 @@ func (management *Management) consumeOpenvpnConnectionOutput(input io.Reader, ou
 		case output <- line:
 		case <-time.After(time.Second):
 			log.Error(management.logPrefix, "Failed to transport line:", line)
+			observer.LineDropped(line)
 		}
 	}
 }
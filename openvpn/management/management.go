@@ -28,6 +28,12 @@ type CommandWriter interface {
 	MultiLineCommand(template string, args ...interface{}) (string, []string, error)
 }
 
+// OutputCommandWriter is implemented by command writers able to send commands which reply with output lines
+// terminated by END marker without leading SUCCESS line, i.e. "status"
+type OutputCommandWriter interface {
+	OutputCommand(template string, args ...interface{}) ([]string, error)
+}
+
 // Middleware used to control openvpn process through management interface
 // It's guaranteed that ConsumeLine callback will be called AFTER Start callback is finished
 // CommandWriter passed on Stop callback can be already closed - expect errors when sending commands
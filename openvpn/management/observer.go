@@ -0,0 +1,50 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package management
+
+import "time"
+
+// Observer receives instrumentation of the management interface, i.e. to collect metrics.
+// Implementations must be safe for concurrent use and must not block.
+type Observer interface {
+	// CommandCompleted is called when response for the management command is received or command failed.
+	// Command is the name of the command without arguments.
+	CommandCompleted(command string, duration time.Duration, err error)
+	// LineDropped is called when line received from openvpn could not be delivered in time.
+	LineDropped(line string)
+}
+
+var observer Observer = noopObserver{}
+
+// UseObserver sets go-openvpn library management interface observer.
+func UseObserver(o Observer) {
+	observer = o
+}
+
+// UseDefaultObserver resets observer to the default one, which ignores everything.
+func UseDefaultObserver() {
+	observer = noopObserver{}
+}
+
+type noopObserver struct{}
+
+func (noopObserver) CommandCompleted(string, time.Duration, error) {}
+
+func (noopObserver) LineDropped(string) {}
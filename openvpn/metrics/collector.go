@@ -0,0 +1,218 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/credentials"
+)
+
+// Deny reason labels, free text deny reasons are mapped to them to keep the number of series bounded.
+const (
+	ReasonCredentials = "credentials"
+	ReasonThrottle    = "throttle"
+	ReasonPolicy      = "policy"
+	ReasonOther       = "other"
+)
+
+var denyReasons = map[string]string{
+	"missing username or password":      ReasonCredentials,
+	"wrong username or password":        ReasonCredentials,
+	credentials.ErrInvalidToken.Error(): ReasonCredentials,
+	credentials.ErrTokenExpired.Error(): ReasonCredentials,
+	credentials.ErrTokenRevoked.Error(): ReasonCredentials,
+	credentials.ErrLockedOut.Error():    ReasonThrottle,
+	credentials.ErrDenied.Error():       ReasonThrottle,
+	"internal error":                    ReasonOther,
+}
+
+// Collector turns openvpn events into metrics. Its handlers are meant to be subscribed to
+// the corresponding middlewares, and it implements management.Observer:
+//
+//	collector := metrics.NewCollector()
+//	management.UseObserver(collector)
+//	authMiddleware.ClientsSubscribe(collector.HandleClientEvent)
+//	authMiddleware.DecisionSubscribe(collector.HandleDecision)
+//	http.Handle("/metrics", collector)
+type Collector struct {
+	registry *Registry
+
+	connectedClients *Gauge
+	authDecisions    *Counter
+	clientBytesIn    *Gauge
+	clientBytesOut   *Gauge
+	bytesIn          *Counter
+	bytesOut         *Counter
+	stateTransitions *Counter
+	processRestarts  *Counter
+	commandDuration  *Histogram
+	commandErrors    *Counter
+	droppedLines     *Counter
+
+	mu        sync.Mutex
+	connected map[int]struct{}
+	lastBytes map[int]bytecount.SessionByteCount
+	started   bool
+}
+
+// NewCollector creates collector with its own registry.
+func NewCollector() *Collector {
+	r := NewRegistry()
+	return &Collector{
+		registry: r,
+
+		connectedClients: r.Gauge("openvpn_connected_clients", "Number of currently connected clients."),
+		authDecisions:    r.Counter("openvpn_auth_decisions_total", "Client authorization decisions by result and reason.", "result", "reason"),
+		clientBytesIn:    r.Gauge("openvpn_client_received_bytes", "Bytes received from a connected client.", "client_id"),
+		clientBytesOut:   r.Gauge("openvpn_client_sent_bytes", "Bytes sent to a connected client.", "client_id"),
+		bytesIn:          r.Counter("openvpn_received_bytes_total", "Bytes received from all clients."),
+		bytesOut:         r.Counter("openvpn_sent_bytes_total", "Bytes sent to all clients."),
+		stateTransitions: r.Counter("openvpn_state_transitions_total", "OpenVPN process state transitions by new state.", "state"),
+		processRestarts:  r.Counter("openvpn_process_restarts_total", "Times OpenVPN process was started again after the first start."),
+		commandDuration:  r.Histogram("openvpn_management_command_duration_seconds", "Management command latency.", DefaultBuckets, "command"),
+		commandErrors:    r.Counter("openvpn_management_command_errors_total", "Failed management commands.", "command"),
+		droppedLines:     r.Counter("openvpn_management_dropped_lines_total", "Management lines which could not be delivered."),
+
+		connected: make(map[int]struct{}),
+		lastBytes: make(map[int]bytecount.SessionByteCount),
+	}
+}
+
+// Registry returns the registry metrics are kept in, so that custom metrics can be added.
+func (c *Collector) Registry() *Registry {
+	return c.registry
+}
+
+// ServeHTTP serves collected metrics in Prometheus text format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.registry.ServeHTTP(w, r)
+}
+
+// HandleClientEvent tracks connected clients.
+func (c *Collector) HandleClientEvent(event server.ClientEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch event.EventType {
+	case server.Established:
+		c.connected[event.ClientID] = struct{}{}
+	case server.Disconnect:
+		delete(c.connected, event.ClientID)
+		c.forgetClient(event.ClientID)
+	default:
+		return
+	}
+	c.connectedClients.Set(float64(len(c.connected)))
+}
+
+// HandleDecision counts authorization accepts and denies by reason.
+func (c *Collector) HandleDecision(decision auth.Decision) {
+	if decision.Accepted {
+		c.authDecisions.Inc("accept", "")
+		return
+	}
+	c.authDecisions.Inc("deny", denyReason(decision.Reason))
+}
+
+// denyReason maps deny reason to a label, reasons not produced by the credentials middleware come from checks,
+// i.e. policies.
+func denyReason(reason string) string {
+	if label, ok := denyReasons[reason]; ok {
+		return label
+	}
+	if reason == "" {
+		return ReasonOther
+	}
+	return ReasonPolicy
+}
+
+// HandleByteCount tracks per-client and total traffic.
+func (c *Collector) HandleByteCount(count bytecount.SessionByteCount) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last := c.lastBytes[count.ClientID]
+	c.lastBytes[count.ClientID] = count
+
+	c.bytesIn.Add(float64(delta(last.BytesIn, count.BytesIn)))
+	c.bytesOut.Add(float64(delta(last.BytesOut, count.BytesOut)))
+
+	id := strconv.Itoa(count.ClientID)
+	c.clientBytesIn.Set(float64(count.BytesIn), id)
+	c.clientBytesOut.Set(float64(count.BytesOut), id)
+}
+
+// HandleState counts state transitions and process restarts.
+func (c *Collector) HandleState(state openvpn.State) {
+	c.stateTransitions.Inc(string(state))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch state {
+	case openvpn.ProcessStarted:
+		if c.started {
+			c.processRestarts.Inc()
+		}
+		c.started = true
+	case openvpn.ProcessExited:
+		c.connected = make(map[int]struct{})
+		c.lastBytes = make(map[int]bytecount.SessionByteCount)
+		c.clientBytesIn.Reset()
+		c.clientBytesOut.Reset()
+		c.connectedClients.Set(0)
+	}
+}
+
+// CommandCompleted records management command latency and failures.
+func (c *Collector) CommandCompleted(command string, duration time.Duration, err error) {
+	c.commandDuration.Observe(duration.Seconds(), command)
+	if err != nil {
+		c.commandErrors.Inc(command)
+	}
+}
+
+// LineDropped counts management lines which were not delivered.
+func (c *Collector) LineDropped(string) {
+	c.droppedLines.Inc()
+}
+
+func (c *Collector) forgetClient(clientID int) {
+	delete(c.lastBytes, clientID)
+	id := strconv.Itoa(clientID)
+	c.clientBytesIn.Delete(id)
+	c.clientBytesOut.Delete(id)
+}
+
+// delta returns growth of the counter, counter reset is treated as growth from zero.
+func delta(previous, current uint64) uint64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
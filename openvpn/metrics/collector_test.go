@@ -0,0 +1,114 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+func scrape(t *testing.T, c *Collector) string {
+	var out bytes.Buffer
+	assert.NoError(t, c.Registry().Write(&out))
+	return out.String()
+}
+
+func TestCollectorTracksClients(t *testing.T) {
+	c := NewCollector()
+	c.HandleClientEvent(server.ClientEvent{EventType: server.Established, ClientID: 1})
+	c.HandleClientEvent(server.ClientEvent{EventType: server.Established, ClientID: 2})
+	c.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 100, BytesOut: 1000})
+	c.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 150, BytesOut: 1200})
+	c.HandleByteCount(bytecount.SessionByteCount{ClientID: 2, BytesIn: 10, BytesOut: 20})
+	c.HandleClientEvent(server.ClientEvent{EventType: server.Disconnect, ClientID: 2})
+
+	out := scrape(t, c)
+	assert.Contains(t, out, "openvpn_connected_clients 1\n")
+	assert.Contains(t, out, "openvpn_client_received_bytes{client_id=\"1\"} 150\n")
+	assert.Contains(t, out, "openvpn_client_sent_bytes{client_id=\"1\"} 1200\n")
+	assert.NotContains(t, out, "client_id=\"2\"")
+	assert.Contains(t, out, "openvpn_received_bytes_total 160\n")
+	assert.Contains(t, out, "openvpn_sent_bytes_total 1220\n")
+}
+
+func TestCollectorCountsDecisions(t *testing.T) {
+	c := NewCollector()
+	c.HandleDecision(auth.Decision{ClientID: 1, Accepted: true})
+	c.HandleDecision(auth.Decision{ClientID: 2, Reason: "wrong username or password"})
+	c.HandleDecision(auth.Decision{ClientID: 3, Reason: "missing username or password"})
+	c.HandleDecision(auth.Decision{ClientID: 4, Reason: "too many failed attempts, try again later"})
+	c.HandleDecision(auth.Decision{ClientID: 5, Reason: "source 1.1.1.1 is not in 10.0.0.0/8"})
+	c.HandleDecision(auth.Decision{ClientID: 6, Reason: "OpenVPN 2.4.0 is too old"})
+	c.HandleDecision(auth.Decision{ClientID: 7})
+
+	out := scrape(t, c)
+	assert.Contains(t, out, "openvpn_auth_decisions_total{result=\"accept\",reason=\"\"} 1\n")
+	assert.Contains(t, out, "openvpn_auth_decisions_total{result=\"deny\",reason=\"credentials\"} 2\n")
+	assert.Contains(t, out, "openvpn_auth_decisions_total{result=\"deny\",reason=\"throttle\"} 1\n")
+	assert.Contains(t, out, "openvpn_auth_decisions_total{result=\"deny\",reason=\"policy\"} 2\n")
+	assert.Contains(t, out, "openvpn_auth_decisions_total{result=\"deny\",reason=\"other\"} 1\n")
+	assert.NotContains(t, out, "1.1.1.1")
+}
+
+func TestCollectorCountsStatesAndRestarts(t *testing.T) {
+	c := NewCollector()
+	c.HandleClientEvent(server.ClientEvent{EventType: server.Established, ClientID: 1})
+	for _, state := range []openvpn.State{
+		openvpn.ProcessStarted,
+		openvpn.ConnectedState,
+		openvpn.ProcessExited,
+		openvpn.ProcessStarted,
+		openvpn.ConnectedState,
+	} {
+		c.HandleState(state)
+	}
+
+	out := scrape(t, c)
+	assert.Contains(t, out, "openvpn_state_transitions_total{state=\"CONNECTED\"} 2\n")
+	assert.Contains(t, out, "openvpn_process_restarts_total 1\n")
+	assert.Contains(t, out, "openvpn_connected_clients 0\n")
+}
+
+func TestCollectorObservesManagement(t *testing.T) {
+	c := NewCollector()
+	c.CommandCompleted("client-kill", 2*time.Millisecond, nil)
+	c.CommandCompleted("client-kill", 20*time.Millisecond, errors.New("command error"))
+	c.LineDropped(">CLIENT:ESTABLISHED,1")
+
+	out := scrape(t, c)
+	assert.Contains(t, out, "openvpn_management_command_duration_seconds_bucket{command=\"client-kill\",le=\"0.005\"} 1\n")
+	assert.Contains(t, out, "openvpn_management_command_duration_seconds_count{command=\"client-kill\"} 2\n")
+	assert.Contains(t, out, "openvpn_management_command_errors_total{command=\"client-kill\"} 1\n")
+	assert.Contains(t, out, "openvpn_management_dropped_lines_total 1\n")
+}
+
+func TestCollectorExposesUnlabelledZeroes(t *testing.T) {
+	out := scrape(t, NewCollector())
+	assert.Contains(t, out, "openvpn_connected_clients 0\n")
+	assert.Contains(t, out, "openvpn_process_restarts_total 0\n")
+}
@@ -0,0 +1,298 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are histogram buckets in seconds suitable for management command latency.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type kind string
+
+const (
+	counterKind   = kind("counter")
+	gaugeKind     = kind("gauge")
+	histogramKind = kind("histogram")
+)
+
+// Registry holds metric families and serves them in Prometheus text exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry creates an empty metrics registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// histogram only
+	counts []uint64
+	count  uint64
+}
+
+// Counter is a monotonically increasing metric family.
+type Counter struct {
+	registry *Registry
+	family   *family
+}
+
+// Gauge is a metric family which value can go up and down.
+type Gauge struct {
+	registry *Registry
+	family   *family
+}
+
+// Histogram is a metric family which counts observations in buckets.
+type Histogram struct {
+	registry *Registry
+	family   *family
+}
+
+// Counter registers new counter family with given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{registry: r, family: r.register(name, help, counterKind, labels, nil)}
+}
+
+// Gauge registers new gauge family with given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{registry: r, family: r.register(name, help, gaugeKind, labels, nil)}
+}
+
+// Histogram registers new histogram family with given upper bucket bounds and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &Histogram{registry: r, family: r.register(name, help, histogramKind, labels, sorted)}
+}
+
+func (r *Registry) register(name, help string, k kind, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.families {
+		if f.name == name {
+			panic("metrics: duplicate metric family " + name)
+		}
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	if len(labels) == 0 && k != histogramKind {
+		// unlabelled series are exposed as zero right away
+		f.get(nil)
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// Inc increments the counter series identified by label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds non negative value to the counter series identified by label values.
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.registry.mu.Lock()
+	defer c.registry.mu.Unlock()
+
+	c.family.get(labelValues).value += value
+}
+
+// Set sets the gauge series identified by label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+
+	g.family.get(labelValues).value = value
+}
+
+// Add adds value to the gauge series identified by label values.
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+
+	g.family.get(labelValues).value += value
+}
+
+// Delete removes the gauge series identified by label values.
+func (g *Gauge) Delete(labelValues ...string) {
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+
+	delete(g.family.series, g.family.key(labelValues))
+}
+
+// Reset removes all series of the gauge.
+func (g *Gauge) Reset() {
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+
+	g.family.series = make(map[string]*series)
+}
+
+// Observe records value in the histogram series identified by label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+
+	s := h.family.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.family.buckets))
+	}
+	for i, bound := range h.family.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (f *family) key(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := f.key(labelValues)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Write writes all registered metrics in Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, f := range r.families {
+		f.write(buf)
+	}
+	return buf.Flush()
+}
+
+// ServeHTTP serves registered metrics for scraping.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_ = r.Write(w)
+}
+
+func (f *family) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != histogramKind {
+			writeSample(w, f.name, f.labels, s.labelValues, "", "", s.value)
+			continue
+		}
+		for i, bound := range f.buckets {
+			writeSample(w, f.name+"_bucket", f.labels, s.labelValues, "le", formatFloat(bound), float64(s.counts[i]))
+		}
+		writeSample(w, f.name+"_bucket", f.labels, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(w, f.name+"_sum", f.labels, s.labelValues, "", "", s.value)
+		writeSample(w, f.name+"_count", f.labels, s.labelValues, "", "", float64(s.count))
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		pairs := make([]string, 0, len(labels)+1)
+		for i, label := range labels {
+			pairs = append(pairs, label+`="`+escapeLabelValue(values[i])+`"`)
+		}
+		if extraLabel != "" {
+			pairs = append(pairs, extraLabel+`="`+extraValue+`"`)
+		}
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,92 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWritesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests served.", "code")
+	temperature := r.Gauge("temperature", "Current\ntemperature.")
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{1, 0.1})
+
+	requests.Inc("200")
+	requests.Add(2, "200")
+	requests.Inc(`a"b`)
+	requests.Add(-1, "200")
+	temperature.Set(21.5)
+	temperature.Add(-1)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(3)
+
+	var out bytes.Buffer
+	assert.NoError(t, r.Write(&out))
+	assert.Equal(t, `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{code="200"} 3
+requests_total{code="a\"b"} 1
+# HELP temperature Current\ntemperature.
+# TYPE temperature gauge
+temperature 20.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.55
+latency_seconds_count 3
+`, out.String())
+}
+
+func TestGaugeDeleteRemovesSeries(t *testing.T) {
+	r := NewRegistry()
+	g := r.Gauge("bytes", "Bytes.", "client_id")
+	g.Set(1, "1")
+	g.Set(2, "2")
+	g.Delete("1")
+
+	var out bytes.Buffer
+	assert.NoError(t, r.Write(&out))
+	assert.Equal(t, "# HELP bytes Bytes.\n# TYPE bytes gauge\nbytes{client_id=\"2\"} 2\n", out.String())
+}
+
+func TestRegistryPanicsOnDuplicateFamily(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("dup", "Dup.")
+	assert.Panics(t, func() { r.Gauge("dup", "Dup.") })
+}
+
+func TestRegistryServesHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("up", "Up.").Inc()
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, contentType, recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "up 1\n")
+}
@@ -0,0 +1,197 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package rate
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/client/bytescount"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+// LocalClientID is an identifier used for samples of the client mode byte counter.
+const LocalClientID = 0
+
+// Rate represents throughput in bytes per second.
+type Rate struct {
+	In  float64
+	Out float64
+}
+
+// Snapshot represents rates of all tracked clients.
+type Snapshot struct {
+	Clients map[int]Rate
+	Total   Rate
+}
+
+// Callback is called when rate of the client is updated.
+type Callback func(clientID int, rate Rate)
+
+type counter struct {
+	in, out uint64
+	at      time.Time
+	valid   bool
+
+	rate    Rate
+	hasRate bool
+}
+
+// Tracker turns cumulative byte counts into exponentially weighted moving average rates.
+type Tracker struct {
+	window   time.Duration
+	callback Callback
+	now      func() time.Time
+
+	mu      sync.Mutex
+	clients map[int]*counter
+}
+
+// NewTracker creates new instance of Tracker.
+// Window defines smoothing time constant, zero window disables smoothing.
+// Callback is optional and may be nil.
+func NewTracker(window time.Duration, callback Callback) *Tracker {
+	return &Tracker{
+		window:   window,
+		callback: callback,
+		now:      time.Now,
+		clients:  make(map[int]*counter),
+	}
+}
+
+// HandleSessionByteCount consumes server mode byte count samples.
+func (t *Tracker) HandleSessionByteCount(count bytecount.SessionByteCount) {
+	t.Sample(count.ClientID, count.BytesIn, count.BytesOut)
+}
+
+// HandleBytecount consumes client mode byte count samples.
+func (t *Tracker) HandleBytecount(count bytescount.Bytecount) error {
+	t.Sample(LocalClientID, count.BytesIn, count.BytesOut)
+	return nil
+}
+
+// HandleClientEvent resets client counters when client session (re)starts.
+func (t *Tracker) HandleClientEvent(event server.ClientEvent) {
+	switch event.EventType {
+	case server.Connect, server.Disconnect:
+		t.Remove(event.ClientID)
+	case server.Reauth:
+		t.Reset(event.ClientID)
+	}
+}
+
+// Sample records cumulative byte counts of the client.
+func (t *Tracker) Sample(clientID int, bytesIn, bytesOut uint64) {
+	now := t.now()
+
+	t.mu.Lock()
+	c, ok := t.clients[clientID]
+	if !ok {
+		c = &counter{}
+		t.clients[clientID] = c
+	}
+
+	updated := t.update(c, bytesIn, bytesOut, now)
+	rate := c.rate
+	t.mu.Unlock()
+
+	if updated && t.callback != nil {
+		t.callback(clientID, rate)
+	}
+}
+
+func (t *Tracker) update(c *counter, bytesIn, bytesOut uint64, now time.Time) bool {
+	previous := *c
+	c.in, c.out, c.at, c.valid = bytesIn, bytesOut, now, true
+
+	if !previous.valid || bytesIn < previous.in || bytesOut < previous.out {
+		// first sample or counters were reset - nothing to compare with
+		return false
+	}
+
+	elapsed := now.Sub(previous.at).Seconds()
+	if elapsed <= 0 {
+		return false
+	}
+
+	current := Rate{
+		In:  float64(bytesIn-previous.in) / elapsed,
+		Out: float64(bytesOut-previous.out) / elapsed,
+	}
+
+	if !c.hasRate || t.window <= 0 {
+		c.rate, c.hasRate = current, true
+		return true
+	}
+
+	alpha := 1 - math.Exp(-elapsed/t.window.Seconds())
+	c.rate.In += alpha * (current.In - c.rate.In)
+	c.rate.Out += alpha * (current.Out - c.rate.Out)
+	return true
+}
+
+// Reset forgets last counters of the client, keeping its current rate.
+func (t *Tracker) Reset(clientID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.clients[clientID]; ok {
+		c.valid = false
+	}
+}
+
+// Remove stops tracking of the client.
+func (t *Tracker) Remove(clientID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.clients, clientID)
+}
+
+// Rate returns current rate of the client.
+func (t *Tracker) Rate(clientID int) (Rate, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.clients[clientID]
+	if !ok || !c.hasRate {
+		return Rate{}, false
+	}
+	return c.rate, true
+}
+
+// Snapshot returns current rates of all clients and their sum.
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := Snapshot{Clients: make(map[int]Rate)}
+	for clientID, c := range t.clients {
+		if !c.hasRate {
+			continue
+		}
+		snapshot.Clients[clientID] = c.rate
+		snapshot.Total.In += c.rate.In
+		snapshot.Total.Out += c.rate.Out
+	}
+	return snapshot
+}
@@ -0,0 +1,142 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package rate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/client/bytescount"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestTracker(window time.Duration, callback Callback) (*Tracker, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tracker := NewTracker(window, callback)
+	tracker.now = clock.Now
+	return tracker, clock
+}
+
+func Test_RateWithoutSmoothing(t *testing.T) {
+	var updates []Rate
+	tracker, clock := newTestTracker(0, func(clientID int, rate Rate) {
+		assert.Equal(t, 1, clientID)
+		updates = append(updates, rate)
+	})
+
+	tracker.HandleSessionByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 100, BytesOut: 1000})
+	_, ok := tracker.Rate(1)
+	assert.False(t, ok)
+
+	clock.Advance(2 * time.Second)
+	tracker.HandleSessionByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 300, BytesOut: 1000})
+
+	rate, ok := tracker.Rate(1)
+	assert.True(t, ok)
+	assert.Equal(t, Rate{In: 100, Out: 0}, rate)
+	assert.Equal(t, []Rate{{In: 100, Out: 0}}, updates)
+}
+
+func Test_RateIsSmoothed(t *testing.T) {
+	tracker, clock := newTestTracker(10*time.Second, nil)
+
+	tracker.Sample(1, 0, 0)
+	clock.Advance(time.Second)
+	tracker.Sample(1, 100, 100)
+	clock.Advance(10 * time.Second)
+	tracker.Sample(1, 100, 100)
+
+	rate, _ := tracker.Rate(1)
+	expected := 100 * math.Exp(-1)
+	assert.InDelta(t, expected, rate.In, 0.001)
+	assert.InDelta(t, expected, rate.Out, 0.001)
+}
+
+func Test_CounterResetsAreDetected(t *testing.T) {
+	tracker, clock := newTestTracker(0, nil)
+
+	tracker.Sample(1, 1000, 1000)
+	clock.Advance(time.Second)
+	tracker.Sample(1, 2000, 2000)
+	clock.Advance(time.Second)
+	tracker.Sample(1, 10, 10)
+
+	rate, _ := tracker.Rate(1)
+	assert.Equal(t, Rate{In: 1000, Out: 1000}, rate)
+
+	clock.Advance(time.Second)
+	tracker.Sample(1, 20, 30)
+	rate, _ = tracker.Rate(1)
+	assert.Equal(t, Rate{In: 10, Out: 20}, rate)
+}
+
+func Test_ClientEventsResetCounters(t *testing.T) {
+	tracker, clock := newTestTracker(0, nil)
+
+	tracker.Sample(1, 0, 0)
+	clock.Advance(time.Second)
+	tracker.Sample(1, 100, 100)
+
+	tracker.HandleClientEvent(server.ClientEvent{EventType: server.Reauth, ClientID: 1})
+	clock.Advance(time.Second)
+	tracker.Sample(1, 5000, 5000)
+	rate, _ := tracker.Rate(1)
+	assert.Equal(t, Rate{In: 100, Out: 100}, rate)
+
+	tracker.HandleClientEvent(server.ClientEvent{EventType: server.Disconnect, ClientID: 1})
+	_, ok := tracker.Rate(1)
+	assert.False(t, ok)
+}
+
+func Test_SnapshotAggregatesClients(t *testing.T) {
+	tracker, clock := newTestTracker(0, nil)
+
+	tracker.Sample(1, 0, 0)
+	tracker.Sample(2, 0, 0)
+	tracker.HandleBytecount(bytescount.Bytecount{})
+	clock.Advance(time.Second)
+	tracker.Sample(1, 10, 20)
+	tracker.Sample(2, 30, 40)
+	tracker.HandleBytecount(bytescount.Bytecount{BytesIn: 1, BytesOut: 2})
+
+	assert.Equal(t, Snapshot{
+		Clients: map[int]Rate{
+			1:             {In: 10, Out: 20},
+			2:             {In: 30, Out: 40},
+			LocalClientID: {In: 1, Out: 2},
+		},
+		Total: Rate{In: 41, Out: 62},
+	}, tracker.Snapshot())
+}
@@ -0,0 +1,230 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package accounting
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+const (
+	// ReasonDisconnected is reported when the disconnect reason is unknown.
+	ReasonDisconnected = "disconnected"
+	// ReasonServerStopped is reported for sessions still open when the middleware is stopped.
+	ReasonServerStopped = "server stopped"
+
+	// pendingTimeout bounds how long environment of a connecting client is kept until it is established.
+	// OpenVPN sends no DISCONNECT for clients denied on CONNECT, their environment is dropped after it.
+	pendingTimeout = 5 * time.Minute
+)
+
+type pending struct {
+	env   map[string]string
+	since time.Time
+}
+
+type session struct {
+	env      map[string]string
+	start    time.Time
+	bytesIn  uint64
+	bytesOut uint64
+	reason   string
+}
+
+// Middleware writes a record of every finished client session to the sink.
+//
+// Session byte counts should be fed to HandleByteCount, i.e. by the bytecount middleware.
+// Byte counts and duration reported in DISCONNECT environment take precedence over tracked values.
+type Middleware struct {
+	*auth.Middleware
+
+	sink Sink
+	now  func() time.Time
+
+	mu       sync.Mutex
+	pending  map[int]pending
+	sessions map[int]*session
+}
+
+// NewMiddleware creates new instance of Middleware.
+func NewMiddleware(sink Sink) *Middleware {
+	m := &Middleware{
+		sink:     sink,
+		now:      time.Now,
+		pending:  make(map[int]pending),
+		sessions: make(map[int]*session),
+	}
+	m.Middleware = auth.NewMiddleware(m.handleClientEvent)
+	return m
+}
+
+// Stop writes records of still open sessions and stops the middleware.
+func (m *Middleware) Stop(commandWriter management.CommandWriter) error {
+	m.mu.Lock()
+	end := m.now()
+	for clientID, s := range m.sessions {
+		m.write(m.record(clientID, s, nil, end, ReasonServerStopped))
+		delete(m.sessions, clientID)
+	}
+	m.pending = make(map[int]pending)
+	m.mu.Unlock()
+
+	return m.Middleware.Stop(commandWriter)
+}
+
+// HandleByteCount updates session traffic.
+func (m *Middleware) HandleByteCount(count bytecount.SessionByteCount) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[count.ClientID]; ok {
+		s.bytesIn, s.bytesOut = count.BytesIn, count.BytesOut
+	}
+}
+
+// SetDisconnectReason sets the reason reported when the session ends, i.e. before the client is killed.
+func (m *Middleware) SetDisconnectReason(clientID int, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[clientID]; ok {
+		s.reason = reason
+	}
+}
+
+func (m *Middleware) handleClientEvent(event server.ClientEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch event.EventType {
+	case server.Connect:
+		now := m.now()
+		for clientID, p := range m.pending {
+			if now.Sub(p.since) >= pendingTimeout {
+				delete(m.pending, clientID)
+			}
+		}
+		m.pending[event.ClientID] = pending{env: merge(nil, event.Env), since: now}
+	case server.Reauth:
+		if s, ok := m.sessions[event.ClientID]; ok {
+			s.env = merge(s.env, event.Env)
+		}
+	case server.Established:
+		m.sessions[event.ClientID] = &session{
+			env:   merge(m.pending[event.ClientID].env, event.Env),
+			start: m.now(),
+		}
+		delete(m.pending, event.ClientID)
+	case server.Disconnect:
+		delete(m.pending, event.ClientID)
+		s, ok := m.sessions[event.ClientID]
+		if !ok {
+			// session established before the middleware was started
+			if _, established := event.Env["time_duration"]; !established {
+				return
+			}
+			s = &session{}
+		}
+		delete(m.sessions, event.ClientID)
+		m.write(m.record(event.ClientID, s, event.Env, m.now(), s.reason))
+	}
+}
+
+func (m *Middleware) record(clientID int, s *session, disconnectEnv map[string]string, end time.Time, reason string) Record {
+	env := merge(s.env, disconnectEnv)
+	record := Record{
+		ClientID:    clientID,
+		Username:    env["username"],
+		CommonName:  env["common_name"],
+		VirtualIP:   env["ifconfig_pool_remote_ip"],
+		VirtualIPv6: env["ifconfig_pool_remote_ip6"],
+		Start:       s.start,
+		End:         end,
+		Duration:    end.Sub(s.start),
+		BytesIn:     s.bytesIn,
+		BytesOut:    s.bytesOut,
+		Reason:      reason,
+	}
+	record.RealIP, record.RealPort = realAddress(env)
+
+	if bytes, err := strconv.ParseUint(disconnectEnv["bytes_received"], 10, 64); err == nil {
+		record.BytesIn = bytes
+	}
+	if bytes, err := strconv.ParseUint(disconnectEnv["bytes_sent"], 10, 64); err == nil {
+		record.BytesOut = bytes
+	}
+	if seconds, err := strconv.ParseInt(disconnectEnv["time_duration"], 10, 64); err == nil {
+		record.Duration = time.Duration(seconds) * time.Second
+		if s.start.IsZero() {
+			record.Start = end.Add(-record.Duration)
+		}
+	}
+	if record.Reason == "" {
+		record.Reason = disconnectEnv["signal"]
+	}
+	if record.Reason == "" {
+		record.Reason = ReasonDisconnected
+	}
+	return record
+}
+
+// write must be called with m.mu held.
+func (m *Middleware) write(record Record) {
+	if err := m.sink.Write(record); err != nil {
+		log.Error("Unable to write accounting record of client:", record.ClientID, err)
+	}
+}
+
+// realAddress returns client real address, trusted address is preferred as it is set after authentication.
+func realAddress(env map[string]string) (string, int) {
+	for _, prefix := range []string{"trusted", "untrusted"} {
+		for _, suffix := range []string{"", "6"} {
+			ip := net.ParseIP(env[prefix+"_ip"+suffix])
+			if ip == nil {
+				continue
+			}
+			port, _ := strconv.Atoi(env[prefix+"_port"])
+			return ip.String(), port
+		}
+	}
+	return "", 0
+}
+
+func merge(env, update map[string]string) map[string]string {
+	merged := make(map[string]string, len(env)+len(update))
+	for key, value := range env {
+		merged[key] = value
+	}
+	for key, value := range update {
+		if key == "password" {
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
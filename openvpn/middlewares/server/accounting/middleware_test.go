@@ -0,0 +1,199 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package accounting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+type memorySink struct {
+	records []Record
+	err     error
+}
+
+func (s *memorySink) Write(record Record) error {
+	s.records = append(s.records, record)
+	return s.err
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func newMiddleware(t *testing.T) (*Middleware, *memorySink, *testClock) {
+	sink := &memorySink{}
+	clock := &testClock{now: time.Unix(1591012800, 0).UTC()}
+	m := NewMiddleware(sink)
+	m.now = clock.Now
+	require.NoError(t, m.Start(&management.MockConnection{}))
+	return m, sink, clock
+}
+
+func consume(t *testing.T, m *Middleware, lines ...string) {
+	for _, line := range lines {
+		_, err := m.ConsumeLine(line)
+		require.NoError(t, err, line)
+	}
+}
+
+func Test_RecordsFinishedSession(t *testing.T) {
+	m, sink, clock := newMiddleware(t)
+	start := clock.now
+
+	consume(t, m,
+		">CLIENT:CONNECT,1,2",
+		">CLIENT:ENV,username=alice",
+		">CLIENT:ENV,password=secret",
+		">CLIENT:ENV,common_name=alice-laptop",
+		">CLIENT:ENV,untrusted_ip=1.2.3.4",
+		">CLIENT:ENV,untrusted_port=51000",
+		">CLIENT:ENV,END",
+		">CLIENT:ESTABLISHED,1",
+		">CLIENT:ENV,ifconfig_pool_remote_ip=10.8.0.6",
+		">CLIENT:ENV,END",
+	)
+	m.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 100, BytesOut: 200})
+	assert.Empty(t, sink.records)
+
+	clock.now = clock.now.Add(90 * time.Second)
+	consume(t, m,
+		">CLIENT:DISCONNECT,1",
+		">CLIENT:ENV,bytes_received=1500",
+		">CLIENT:ENV,bytes_sent=3000",
+		">CLIENT:ENV,time_duration=91",
+		">CLIENT:ENV,END",
+	)
+
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, Record{
+		ClientID:   1,
+		Username:   "alice",
+		CommonName: "alice-laptop",
+		RealIP:     "1.2.3.4",
+		RealPort:   51000,
+		VirtualIP:  "10.8.0.6",
+		Start:      start,
+		End:        clock.now,
+		Duration:   91 * time.Second,
+		BytesIn:    1500,
+		BytesOut:   3000,
+		Reason:     ReasonDisconnected,
+	}, sink.records[0])
+}
+
+func Test_TrackedValuesWithoutDisconnectEnv(t *testing.T) {
+	m, sink, clock := newMiddleware(t)
+	start := clock.now
+
+	consume(t, m,
+		">CLIENT:CONNECT,1,2", ">CLIENT:ENV,username=alice", ">CLIENT:ENV,END",
+		">CLIENT:ESTABLISHED,1", ">CLIENT:ENV,END",
+		">CLIENT:REAUTH,1,3", ">CLIENT:ENV,username=bob", ">CLIENT:ENV,END",
+	)
+	m.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 100, BytesOut: 200})
+	m.SetDisconnectReason(1, "data quota exceeded")
+
+	clock.now = clock.now.Add(time.Minute)
+	consume(t, m, ">CLIENT:DISCONNECT,1", ">CLIENT:ENV,END")
+
+	require.Len(t, sink.records, 1)
+	record := sink.records[0]
+	assert.Equal(t, "bob", record.Username)
+	assert.Equal(t, start, record.Start)
+	assert.Equal(t, time.Minute, record.Duration)
+	assert.Equal(t, uint64(100), record.BytesIn)
+	assert.Equal(t, uint64(200), record.BytesOut)
+	assert.Equal(t, "data quota exceeded", record.Reason)
+}
+
+func Test_SkipsClientsWhichNeverEstablished(t *testing.T) {
+	m, sink, _ := newMiddleware(t)
+
+	consume(t, m,
+		">CLIENT:CONNECT,1,2", ">CLIENT:ENV,username=alice", ">CLIENT:ENV,END",
+		">CLIENT:DISCONNECT,1", ">CLIENT:ENV,END",
+	)
+	assert.Empty(t, sink.records)
+}
+
+func Test_ForgetsClientsDeniedOnConnect(t *testing.T) {
+	m, _, clock := newMiddleware(t)
+
+	// denied clients get no DISCONNECT
+	consume(t, m, ">CLIENT:CONNECT,1,2", ">CLIENT:ENV,username=alice", ">CLIENT:ENV,END")
+	clock.now = clock.now.Add(time.Minute)
+	consume(t, m, ">CLIENT:CONNECT,2,2", ">CLIENT:ENV,username=alice", ">CLIENT:ENV,END")
+	assert.Len(t, m.pending, 2)
+
+	clock.now = clock.now.Add(pendingTimeout)
+	consume(t, m, ">CLIENT:CONNECT,3,2", ">CLIENT:ENV,username=bob", ">CLIENT:ENV,END")
+	assert.Len(t, m.pending, 1)
+	assert.Equal(t, "bob", m.pending[3].env["username"])
+}
+
+func Test_SessionEstablishedBeforeStart(t *testing.T) {
+	m, sink, clock := newMiddleware(t)
+
+	consume(t, m,
+		">CLIENT:DISCONNECT,4",
+		">CLIENT:ENV,common_name=router",
+		">CLIENT:ENV,trusted_ip6=2001:db8::1",
+		">CLIENT:ENV,trusted_port=1194",
+		">CLIENT:ENV,time_duration=3600",
+		">CLIENT:ENV,signal=ping-restart",
+		">CLIENT:ENV,END",
+	)
+
+	require.Len(t, sink.records, 1)
+	record := sink.records[0]
+	assert.Equal(t, "router", record.CommonName)
+	assert.Equal(t, "2001:db8::1", record.RealIP)
+	assert.Equal(t, 1194, record.RealPort)
+	assert.Equal(t, clock.now.Add(-time.Hour), record.Start)
+	assert.Equal(t, time.Hour, record.Duration)
+	assert.Equal(t, "ping-restart", record.Reason)
+}
+
+func Test_StopRecordsOpenSessions(t *testing.T) {
+	m, sink, clock := newMiddleware(t)
+	sink.err = errors.New("disk full")
+
+	consume(t, m, ">CLIENT:ESTABLISHED,1", ">CLIENT:ENV,username=alice", ">CLIENT:ENV,END")
+	clock.now = clock.now.Add(time.Hour)
+	require.NoError(t, m.Stop(&management.MockConnection{}))
+
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, ReasonServerStopped, sink.records[0].Reason)
+	assert.Equal(t, time.Hour, sink.records[0].Duration)
+
+	consume(t, m, ">CLIENT:DISCONNECT,1", ">CLIENT:ENV,END")
+	assert.Len(t, sink.records, 1)
+}
@@ -0,0 +1,93 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package accounting
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record describes a finished client session.
+type Record struct {
+	ClientID    int           `json:"client_id"`
+	Username    string        `json:"username,omitempty"`
+	CommonName  string        `json:"common_name,omitempty"`
+	RealIP      string        `json:"real_ip,omitempty"`
+	RealPort    int           `json:"real_port,omitempty"`
+	VirtualIP   string        `json:"virtual_ip,omitempty"`
+	VirtualIPv6 string        `json:"virtual_ipv6,omitempty"`
+	Start       time.Time     `json:"start"`
+	End         time.Time     `json:"end"`
+	Duration    time.Duration `json:"duration_ns"`
+	// BytesIn are bytes received from the client, BytesOut are bytes sent to the client.
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+	Reason   string `json:"reason"`
+}
+
+// Sink stores session records.
+type Sink interface {
+	Write(record Record) error
+}
+
+// JSONLSink writes session records as JSON lines.
+type JSONLSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewJSONLSink creates sink writing to the writer.
+func NewJSONLSink(writer io.Writer) *JSONLSink {
+	return &JSONLSink{encoder: json.NewEncoder(writer)}
+}
+
+// OpenJSONLSink creates sink appending to the file, file is created when it does not exist.
+func OpenJSONLSink(path string) (*JSONLSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	sink := NewJSONLSink(file)
+	sink.closer = file
+	return sink, nil
+}
+
+// Write writes the record as a single line.
+func (s *JSONLSink) Write(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.encoder.Encode(record)
+}
+
+// Close closes the file opened by OpenJSONLSink.
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
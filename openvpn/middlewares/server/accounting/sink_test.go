@@ -0,0 +1,88 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package accounting
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_JSONLSinkWritesLines(t *testing.T) {
+	var buffer bytes.Buffer
+	sink := NewJSONLSink(&buffer)
+
+	require.NoError(t, sink.Write(Record{
+		ClientID: 1,
+		Username: "alice",
+		Start:    time.Unix(1000, 0).UTC(),
+		End:      time.Unix(1060, 0).UTC(),
+		Duration: time.Minute,
+		BytesIn:  10,
+		BytesOut: 20,
+		Reason:   ReasonDisconnected,
+	}))
+	require.NoError(t, sink.Write(Record{ClientID: 2}))
+	assert.NoError(t, sink.Close())
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{
+		"client_id": 1,
+		"username": "alice",
+		"start": "1970-01-01T00:16:40Z",
+		"end": "1970-01-01T00:17:40Z",
+		"duration_ns": 60000000000,
+		"bytes_in": 10,
+		"bytes_out": 20,
+		"reason": "disconnected"
+	}`, lines[0])
+}
+
+func Test_OpenJSONLSinkAppends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounting")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sessions.jsonl")
+
+	for clientID := 1; clientID <= 2; clientID++ {
+		sink, err := OpenJSONLSink(path)
+		require.NoError(t, err)
+		require.NoError(t, sink.Write(Record{ClientID: clientID}))
+		require.NoError(t, sink.Close())
+	}
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+
+	var record Record
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, 2, record.ClientID)
+}
@@ -0,0 +1,128 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package admission
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/credentials"
+)
+
+// DefaultUpgradeMessage is appended to deny reasons when rules don't define their own.
+const DefaultUpgradeMessage = "Please upgrade your OpenVPN client."
+
+// ErrNoValidator is returned by NewMiddleware without credentials validator.
+var ErrNoValidator = errors.New("credentials validator is required")
+
+// ncpCiphers are data channel ciphers implied by IV_NCP=2 of clients which don't report IV_CIPHERS.
+var ncpCiphers = []string{"AES-256-GCM", "AES-128-GCM"}
+
+// Rules defines which client versions and capabilities are admitted.
+// Empty fields don't restrict anything.
+type Rules struct {
+	// MinVersion is the oldest admitted client version.
+	MinVersion server.Version
+	// RequiredCiphers lists data channel ciphers which client must support.
+	RequiredCiphers []string
+	// RequiredProtocol lists protocol capabilities which client must support.
+	RequiredProtocol server.ProtocolFlags
+	// BlockedPlatforms lists platforms which are not admitted.
+	BlockedPlatforms []server.Platform
+	// UpgradeMessage is appended to deny reasons.
+	UpgradeMessage string
+}
+
+// NewMiddleware creates credentials middleware which admits clients by the rules first
+// and validates their username and password afterwards.
+// Validator is required, to admit clients by rules only pass Check to credentials.NewMiddleware explicitly.
+func NewMiddleware(rules Rules, validator credentials.Validator) (*credentials.Middleware, error) {
+	if validator == nil {
+		return nil, ErrNoValidator
+	}
+	return credentials.NewMiddleware(validator, rules.Check), nil
+}
+
+// Check admits client event by its peer info, it conforms to credentials.Check callback.
+func (r Rules) Check(event server.ClientEvent) error {
+	info := event.Info()
+
+	reason := r.check(info, event.Env)
+	if reason == "" {
+		log.Info("Admitted client with ID:", event.ClientID, "version:", info.Version.Raw, "platform:", info.Platform)
+		return nil
+	}
+
+	log.Warn("Rejected client with ID:", event.ClientID, "version:", info.Version.Raw, "platform:", info.Platform, "reason:", reason)
+
+	upgrade := r.UpgradeMessage
+	if upgrade == "" {
+		upgrade = DefaultUpgradeMessage
+	}
+	return errors.New(reason + ". " + upgrade)
+}
+
+func (r Rules) check(info server.ClientInfo, env map[string]string) string {
+	for _, platform := range r.BlockedPlatforms {
+		if info.Platform == platform {
+			return fmt.Sprintf("Platform %s is not supported", platform)
+		}
+	}
+
+	if r.MinVersion != (server.Version{}) {
+		if info.Version.Raw == "" {
+			return "Client version is unknown"
+		}
+		if !info.Version.AtLeast(r.MinVersion) {
+			return fmt.Sprintf("OpenVPN %s is too old, version %s or newer is required", info.Version.Raw, r.MinVersion)
+		}
+	}
+
+	ciphers := info.Ciphers
+	if len(ciphers) == 0 {
+		if ncp, err := strconv.Atoi(env["IV_NCP"]); err == nil && ncp >= 2 {
+			ciphers = ncpCiphers
+		}
+	}
+	for _, required := range r.RequiredCiphers {
+		if !containsFold(ciphers, required) {
+			return fmt.Sprintf("Cipher %s is not supported by the client", required)
+		}
+	}
+
+	if !info.Protocol.Has(r.RequiredProtocol) {
+		return "Client does not support required protocol features"
+	}
+
+	return ""
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
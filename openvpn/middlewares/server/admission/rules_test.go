@@ -0,0 +1,122 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package admission
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+func event(env map[string]string) server.ClientEvent {
+	return server.ClientEvent{EventType: server.Connect, ClientID: 1, ClientKey: 2, Env: env}
+}
+
+func Test_RulesCheck(t *testing.T) {
+	v25, _ := server.ParseVersion("2.5.0")
+	rules := Rules{
+		MinVersion:       v25,
+		RequiredCiphers:  []string{"AES-256-GCM"},
+		RequiredProtocol: server.ProtoDataV2,
+		BlockedPlatforms: []server.Platform{server.PlatformSolaris},
+	}
+
+	var tests = []struct {
+		env      map[string]string
+		expected string
+	}{
+		{
+			map[string]string{"IV_VER": "2.5.3", "IV_PLAT": "linux", "IV_PROTO": "2", "IV_CIPHERS": "AES-256-GCM:AES-128-GCM"},
+			"",
+		},
+		{
+			map[string]string{"IV_VER": "2.4.9", "IV_PLAT": "linux", "IV_PROTO": "2", "IV_CIPHERS": "AES-256-GCM"},
+			"OpenVPN 2.4.9 is too old, version 2.5.0 or newer is required. Please upgrade your OpenVPN client.",
+		},
+		{
+			map[string]string{"IV_PLAT": "linux"},
+			"Client version is unknown. Please upgrade your OpenVPN client.",
+		},
+		{
+			map[string]string{"IV_VER": "2.5.3", "IV_PLAT": "solaris", "IV_PROTO": "2", "IV_CIPHERS": "AES-256-GCM"},
+			"Platform solaris is not supported. Please upgrade your OpenVPN client.",
+		},
+		{
+			map[string]string{"IV_VER": "2.5.3", "IV_PROTO": "2", "IV_CIPHERS": "BF-CBC"},
+			"Cipher AES-256-GCM is not supported by the client. Please upgrade your OpenVPN client.",
+		},
+		{
+			map[string]string{"IV_VER": "2.5.3", "IV_PROTO": "2", "IV_NCP": "2"},
+			"",
+		},
+		{
+			map[string]string{"IV_VER": "2.5.3", "IV_PROTO": "4", "IV_CIPHERS": "AES-256-GCM"},
+			"Client does not support required protocol features. Please upgrade your OpenVPN client.",
+		},
+	}
+
+	for _, test := range tests {
+		err := rules.Check(event(test.env))
+		if test.expected == "" {
+			assert.NoError(t, err, "%v", test.env)
+		} else {
+			assert.EqualError(t, err, test.expected, "%v", test.env)
+		}
+	}
+}
+
+func Test_MiddlewareDeniesWithUpgradeMessage(t *testing.T) {
+	v25, _ := server.ParseVersion("2.5")
+	mockConnection := &management.MockConnection{}
+	validator := func(clientID int, username, password string) (bool, error) {
+		return username == "alice" && password == "secret", nil
+	}
+	middleware, err := NewMiddleware(Rules{MinVersion: v25, UpgradeMessage: "Get the new client at https://example.com"}, validator)
+	require.NoError(t, err)
+	middleware.Start(mockConnection)
+
+	middleware.ConsumeLine(">CLIENT:CONNECT,1,2")
+	middleware.ConsumeLine(">CLIENT:ENV,username=alice")
+	middleware.ConsumeLine(">CLIENT:ENV,password=secret")
+	middleware.ConsumeLine(">CLIENT:ENV,IV_VER=2.4.0")
+	middleware.ConsumeLine(">CLIENT:ENV,END")
+	assert.Equal(t, `client-deny 1 2 "OpenVPN 2.4.0 is too old, version 2.5.0 or newer is required. Get the new client at https://example.com" "OpenVPN 2.4.0 is too old, version 2.5.0 or newer is required. Get the new client at https://example.com"`, mockConnection.LastLine)
+
+	middleware.ConsumeLine(">CLIENT:CONNECT,3,4")
+	middleware.ConsumeLine(">CLIENT:ENV,username=alice")
+	middleware.ConsumeLine(">CLIENT:ENV,password=secret")
+	middleware.ConsumeLine(">CLIENT:ENV,IV_VER=2.6.1")
+	middleware.ConsumeLine(">CLIENT:ENV,END")
+	assert.Equal(t, "client-auth-nt 3 4", mockConnection.LastLine)
+
+	middleware.ConsumeLine(">CLIENT:CONNECT,5,6")
+	middleware.ConsumeLine(">CLIENT:ENV,IV_VER=2.6.1")
+	middleware.ConsumeLine(">CLIENT:ENV,END")
+	assert.Equal(t, `client-deny 5 6 "missing username or password" "missing username or password"`, mockConnection.LastLine)
+}
+
+func Test_MiddlewareRequiresValidator(t *testing.T) {
+	middleware, err := NewMiddleware(Rules{}, nil)
+	assert.Equal(t, ErrNoValidator, err)
+	assert.Nil(t, middleware)
+}
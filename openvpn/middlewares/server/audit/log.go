@@ -0,0 +1,178 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+)
+
+// Options configure the audit log.
+type Options struct {
+	// MaxFileSize is the size in bytes after which the log continues in a new file, zero disables rotation.
+	MaxFileSize int64
+	// Sync flushes every record to stable storage before returning.
+	Sync bool
+}
+
+// Log is an append-only, hash-chained authentication audit log stored as JSON lines files in a directory.
+// Rotated files are never removed or rewritten, the chain continues across them.
+//
+// Subscribe it to authentication decisions of the auth or credentials middleware:
+//
+//	middleware.DecisionSubscribe(auditLog.Record)
+type Log struct {
+	dir     string
+	options Options
+
+	mu       sync.Mutex
+	file     *os.File
+	index    int
+	size     int64
+	seq      uint64
+	prevHash string
+}
+
+// Open opens the audit log in the directory, continuing the chain of existing records.
+func Open(dir string, options Options) (*Log, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, options: options, index: 1}
+	if len(files) > 0 {
+		l.index = files[len(files)-1].index
+		last, found, err := lastRecord(files)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			l.seq = last.Seq
+			l.prevHash = last.Hash
+		}
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record appends the decision to the log, failures are logged.
+func (l *Log) Record(decision auth.Decision) {
+	if _, err := l.Append(decision); err != nil {
+		log.Error("Unable to write audit record:", err)
+	}
+}
+
+// Append chains the decision and appends it to the log.
+func (l *Log) Append(decision auth.Decision) (Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return Record{}, os.ErrClosed
+	}
+
+	record := NewRecord(decision)
+	record.Seq = l.seq + 1
+	record.PrevHash = l.prevHash
+	hash, err := record.ComputeHash()
+	if err != nil {
+		return Record{}, err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return Record{}, err
+	}
+	line = append(line, '\n')
+
+	if l.options.MaxFileSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.options.MaxFileSize {
+		if err := l.rotate(); err != nil {
+			return Record{}, err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return Record{}, err
+	}
+	if l.options.Sync {
+		if err := l.file.Sync(); err != nil {
+			return Record{}, err
+		}
+	}
+
+	l.seq = record.Seq
+	l.prevHash = record.Hash
+	return record, nil
+}
+
+// Close closes the current log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.index++
+	return l.openFile()
+}
+
+func (l *Log) openFile() error {
+	file, err := os.OpenFile(filepath.Join(l.dir, fileName(l.index)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+func fileName(index int) string {
+	return fmt.Sprintf("audit-%08d.jsonl", index)
+}
@@ -0,0 +1,114 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+)
+
+var start = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func decision(i int, accepted bool) auth.Decision {
+	d := auth.Decision{
+		Time:      start.Add(time.Duration(i) * time.Minute),
+		ClientID:  i,
+		ClientKey: 0,
+		Username:  "alice",
+		SourceIP:  "192.0.2.1",
+		Accepted:  accepted,
+		Latency:   20 * time.Millisecond,
+	}
+	if !accepted {
+		d.Username = "mallory"
+		d.Reason = "wrong username or password"
+	}
+	return d
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	return dir
+}
+
+func TestLogChainsRecords(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+
+	first, err := l.Append(decision(1, true))
+	require.NoError(t, err)
+	second, err := l.Append(decision(2, false))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	assert.Equal(t, uint64(1), first.Seq)
+	assert.Empty(t, first.PrevHash)
+	assert.Equal(t, Accept, first.Decision)
+	assert.Equal(t, uint64(2), second.Seq)
+	assert.Equal(t, first.Hash, second.PrevHash)
+	assert.Equal(t, Deny, second.Decision)
+	assert.Equal(t, "wrong username or password", second.Reason)
+	assert.NoError(t, Verify(dir))
+}
+
+func TestLogRotatesAndContinuesChainAfterReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, err := Open(dir, Options{MaxFileSize: 300, Sync: true})
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		_, err := l.Append(decision(i, true))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	l, err = Open(dir, Options{MaxFileSize: 300})
+	require.NoError(t, err)
+	record, err := l.Append(decision(4, false))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	require.NoError(t, err)
+	assert.Len(t, files, 4)
+	assert.Equal(t, uint64(4), record.Seq)
+	assert.NoError(t, Verify(dir))
+}
+
+func TestLogRejectsAppendAfterClose(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	_, err = l.Append(decision(1, true))
+	assert.Error(t, err)
+}
@@ -0,0 +1,181 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const maxLineSize = 1024 * 1024
+
+// ChainError reports a record which breaks the audit log hash chain.
+type ChainError struct {
+	File   string
+	Line   int
+	Seq    uint64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at %s:%d (seq %d): %s", e.File, e.Line, e.Seq, e.Reason)
+}
+
+// Read calls fn for every record of the audit log in the directory matching the filter, oldest first.
+// Reading stops at the first error returned by fn.
+func Read(dir string, filter Filter, fn func(Record) error) error {
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		err := readFile(f.path, func(_ int, record Record) error {
+			if !filter.Match(record) {
+				return nil
+			}
+			return fn(record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find returns all records of the audit log in the directory matching the filter.
+func Find(dir string, filter Filter) ([]Record, error) {
+	var records []Record
+	err := Read(dir, filter, func(record Record) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// Verify checks that the audit log in the directory was not altered:
+// sequence numbers are contiguous, every record hash matches its content
+// and every record refers to the hash of the previous one.
+// Broken chain is reported as *ChainError.
+func Verify(dir string) error {
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	var seq uint64
+	var prevHash string
+	for _, f := range files {
+		err := readFile(f.path, func(line int, record Record) error {
+			fail := func(reason string) error {
+				return &ChainError{File: f.path, Line: line, Seq: record.Seq, Reason: reason}
+			}
+			if record.Seq != seq+1 {
+				return fail(fmt.Sprintf("expected sequence %d", seq+1))
+			}
+			if record.PrevHash != prevHash {
+				return fail("previous hash mismatch")
+			}
+			hash, err := record.ComputeHash()
+			if err != nil {
+				return err
+			}
+			if hash != record.Hash {
+				return fail("record hash mismatch")
+			}
+			seq, prevHash = record.Seq, record.Hash
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type logFile struct {
+	path  string
+	index int
+}
+
+func listFiles(dir string) ([]logFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []logFile
+	for _, entry := range entries {
+		var index int
+		if entry.IsDir() {
+			continue
+		}
+		if _, err := fmt.Sscanf(entry.Name(), "audit-%08d.jsonl", &index); err != nil || entry.Name() != fileName(index) {
+			continue
+		}
+		files = append(files, logFile{path: filepath.Join(dir, entry.Name()), index: index})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].index < files[j].index
+	})
+	return files, nil
+}
+
+func readFile(path string, fn func(line int, record Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return &ChainError{File: path, Line: line, Reason: "malformed record: " + err.Error()}
+		}
+		if err := fn(line, record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// lastRecord finds the newest record in the files.
+func lastRecord(files []logFile) (Record, bool, error) {
+	for i := len(files) - 1; i >= 0; i-- {
+		var last Record
+		found := false
+		err := readFile(files[i].path, func(_ int, record Record) error {
+			last, found = record, true
+			return nil
+		})
+		if err != nil || found {
+			return last, found, err
+		}
+	}
+	return Record{}, false, nil
+}
@@ -0,0 +1,100 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLog(t *testing.T, decisions int) string {
+	dir := tempDir(t)
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	for i := 1; i <= decisions; i++ {
+		_, err := l.Append(decision(i, i%2 == 1))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+	return dir
+}
+
+func TestFindFiltersRecords(t *testing.T) {
+	dir := writeLog(t, 5)
+	defer os.RemoveAll(dir)
+
+	denied, err := Find(dir, Filter{Decision: Deny})
+	require.NoError(t, err)
+	assert.Len(t, denied, 2)
+	assert.Equal(t, "mallory", denied[0].Username)
+
+	window, err := Find(dir, Filter{Username: "alice", From: start.Add(2 * time.Minute), To: start.Add(5 * time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, window, 1)
+	assert.Equal(t, uint64(3), window[0].Seq)
+	assert.Equal(t, 20*time.Millisecond, window[0].Latency)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	var tests = []struct {
+		name   string
+		tamper func(lines []string) []string
+		reason string
+	}{
+		{
+			name: "modified record",
+			tamper: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], "mallory", "alice", 1)
+				return lines
+			},
+			reason: "record hash mismatch",
+		},
+		{
+			name: "removed record",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			reason: "expected sequence 2",
+		},
+	}
+
+	for _, test := range tests {
+		dir := writeLog(t, 3)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, fileName(1))
+		content, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+		lines = test.tamper(lines)
+		require.NoError(t, ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+		err = Verify(dir)
+		require.IsType(t, &ChainError{}, err, test.name)
+		assert.Equal(t, test.reason, err.(*ChainError).Reason, test.name)
+		assert.Equal(t, 2, err.(*ChainError).Line, test.name)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+)
+
+const (
+	// Accept is the decision of accepted client.
+	Accept = "accept"
+	// Deny is the decision of denied client.
+	Deny = "deny"
+)
+
+// Record is a single authentication decision entry of the audit log.
+// Records are chained: each one carries the hash of the previous record.
+type Record struct {
+	Seq       uint64        `json:"seq"`
+	Time      time.Time     `json:"time"`
+	ClientID  int           `json:"cid"`
+	ClientKey int           `json:"kid"`
+	Username  string        `json:"username,omitempty"`
+	SourceIP  string        `json:"source_ip,omitempty"`
+	Decision  string        `json:"decision"`
+	Reason    string        `json:"reason,omitempty"`
+	Latency   time.Duration `json:"latency_ns"`
+	PrevHash  string        `json:"prev_hash"`
+	Hash      string        `json:"hash"`
+}
+
+// NewRecord creates unchained record from the authentication decision.
+func NewRecord(decision auth.Decision) Record {
+	record := Record{
+		Time:      decision.Time.UTC(),
+		ClientID:  decision.ClientID,
+		ClientKey: decision.ClientKey,
+		Username:  decision.Username,
+		SourceIP:  decision.SourceIP,
+		Decision:  Deny,
+		Reason:    decision.Reason,
+		Latency:   decision.Latency,
+	}
+	if decision.Accepted {
+		record.Decision = Accept
+	}
+	return record
+}
+
+// ComputeHash calculates hash of the record content including the previous record hash.
+func (r Record) ComputeHash() (string, error) {
+	r.Hash = ""
+	content, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Filter selects records when reading the audit log. Zero fields match everything.
+type Filter struct {
+	Username string
+	SourceIP string
+	Decision string
+	From, To time.Time
+}
+
+// Match checks if record satisfies the filter.
+func (f Filter) Match(r Record) bool {
+	switch {
+	case f.Username != "" && f.Username != r.Username:
+		return false
+	case f.SourceIP != "" && f.SourceIP != r.SourceIP:
+		return false
+	case f.Decision != "" && f.Decision != r.Decision:
+		return false
+	case !f.From.IsZero() && r.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !r.Time.Before(f.To):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+// KillReason tells the killed client what to do next.
+type KillReason string
+
+const (
+	// KillHalt tells the client to exit.
+	KillHalt = KillReason("HALT")
+	// KillRestart tells the client to reconnect.
+	KillRestart = KillReason("RESTART")
+)
+
+// Client is a connected client as seen by the middleware.
+type Client struct {
+	ClientID int
+	Since    time.Time
+	// Env is the client environment reported on connection establishment.
+	Env map[string]string
+}
+
+// Info returns typed client info parsed from client environment.
+func (c Client) Info() server.ClientInfo {
+	return server.ParseClientInfo(c.Env)
+}
+
+// ClientMatcher selects clients, i.e. for draining.
+type ClientMatcher func(client Client) bool
+
+// Clients returns currently connected clients ordered by client ID.
+func (m *Middleware) Clients() []Client {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+
+	clients := make([]Client, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ClientID < clients[j].ClientID
+	})
+	return clients
+}
+
+// Drain kills every connected client matching the matcher with given reason and message.
+// All matching clients are attempted, the first error is returned along with the number of killed sessions.
+func (m *Middleware) Drain(match ClientMatcher, reason KillReason, message string) (int, error) {
+	var killed int
+	var firstErr error
+	for _, client := range m.Clients() {
+		if !match(client) {
+			continue
+		}
+		n, err := m.ClientKillWithReason(client.ClientID, reason, message)
+		killed += n
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return killed, firstErr
+}
+
+func (m *Middleware) trackClient(event server.ClientEvent) {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+
+	switch event.EventType {
+	case server.Established:
+		m.clients[event.ClientID] = Client{ClientID: event.ClientID, Since: m.now(), Env: event.Env}
+	case server.Disconnect:
+		delete(m.clients, event.ClientID)
+	}
+}
+
+var killedRule = regexp.MustCompile(`(\d+) client\(s\)`)
+
+// killedCount extracts the number of killed sessions from the kill command output.
+func killedCount(output string) (int, error) {
+	match := killedRule.FindStringSubmatch(output)
+	if match == nil {
+		return 0, errors.New("unexpected kill output: " + output)
+	}
+	return strconv.Atoi(match[1])
+}
+
+// killNotFound treats management errors about unknown clients as nothing killed.
+func killNotFound(err error) (int, error) {
+	if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "command failed") {
+		return 0, nil
+	}
+	return 0, err
+}
+
+// quote quotes management command parameter, so that it may contain spaces.
+// Line breaks are replaced with spaces, as they would end the command and start another one.
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r\n", " ", "\r", " ", "\n", " ").Replace(value) + `"`
+}
@@ -0,0 +1,154 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+)
+
+// scriptedConnection answers commands with predefined outputs.
+type scriptedConnection struct {
+	management.MockConnection
+	outputs map[string]string
+	errors  map[string]error
+}
+
+func (c *scriptedConnection) SingleLineCommand(format string, args ...interface{}) (string, error) {
+	c.MockConnection.SingleLineCommand(format, args...)
+	cmd := fmt.Sprintf(format, args...)
+	return c.outputs[cmd], c.errors[cmd]
+}
+
+func Test_ClientDenySendsMessage(t *testing.T) {
+	connection := &management.MockConnection{}
+	middleware := NewMiddleware()
+	middleware.Start(connection)
+
+	assert.NoError(t, middleware.ClientDeny(1, 2, "blocked"))
+	assert.NoError(t, middleware.ClientDeny(1, 3, ""))
+	assert.Equal(t, []string{`client-deny 1 2 "blocked" "blocked"`, "client-deny 1 3"}, connection.WrittenLines)
+}
+
+func Test_MessagesWithSpacesAreQuoted(t *testing.T) {
+	connection := &management.MockConnection{}
+	middleware := NewMiddleware()
+	middleware.Start(connection)
+
+	assert.NoError(t, middleware.ClientDenyWithMessage(1, 2, `version 2.4 is "too old"`))
+	assert.NoError(t, middleware.ClientKillWithMessage(3, "HALT,data quota exceeded"))
+	_, err := middleware.ClientKillWithReason(4, KillRestart, "server restarting")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		`client-deny 1 2 "version 2.4 is \"too old\"" "version 2.4 is \"too old\""`,
+		`client-kill 3 "HALT,data quota exceeded"`,
+		`client-kill 4 "RESTART,server restarting"`,
+	}, connection.WrittenLines)
+}
+
+func Test_LineBreaksDoNotInjectCommands(t *testing.T) {
+	connection := &management.MockConnection{}
+	middleware := NewMiddleware()
+	middleware.Start(connection)
+
+	assert.NoError(t, middleware.ClientDenyWithMessage(1, 2, "denied\nclient-kill 5\r\nsignal SIGTERM\r"))
+	assert.Equal(t, []string{
+		`client-deny 1 2 "denied client-kill 5 signal SIGTERM " "denied client-kill 5 signal SIGTERM "`,
+	}, connection.WrittenLines)
+	assert.NotContains(t, connection.LastLine, "\n")
+	assert.NotContains(t, connection.LastLine, "\r")
+}
+
+func Test_KillControlsReportKilledSessions(t *testing.T) {
+	connection := &scriptedConnection{
+		outputs: map[string]string{
+			`kill "John Doe"`:    "common name 'John Doe' found, 2 client(s) killed",
+			"kill 10.0.0.1:1194": "1 client(s) at address 10.0.0.1:1194 killed",
+		},
+		errors: map[string]error{
+			"kill 10.0.0.2:1194":            errors.New("command error: client(s) at address 10.0.0.2:1194 not found"),
+			`client-kill 9 "RESTART"`:       errors.New("command error: client-kill command failed"),
+			`client-kill 7 "HALT,shutdown"`: nil,
+		},
+	}
+	middleware := NewMiddleware()
+	middleware.Start(connection)
+
+	killed, err := middleware.KillCommonName("John Doe")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, killed)
+
+	killed, err = middleware.KillAddress("10.0.0.1", 1194)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, killed)
+
+	killed, err = middleware.KillAddress("10.0.0.2", 1194)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, killed)
+
+	killed, err = middleware.ClientKillWithReason(7, KillHalt, "shutdown")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, killed)
+
+	killed, err = middleware.ClientKillWithReason(9, KillRestart, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, killed)
+
+	assert.Equal(t, `client-kill 9 "RESTART"`, connection.LastLine)
+}
+
+func Test_DrainKillsMatchingClients(t *testing.T) {
+	connection := &management.MockConnection{}
+	middleware := NewMiddleware()
+	middleware.Start(connection)
+
+	for _, line := range []string{
+		">CLIENT:ESTABLISHED,1",
+		">CLIENT:ENV,common_name=alice",
+		">CLIENT:ENV,END",
+		">CLIENT:ESTABLISHED,2",
+		">CLIENT:ENV,common_name=bob",
+		">CLIENT:ENV,END",
+		">CLIENT:ESTABLISHED,3",
+		">CLIENT:ENV,common_name=alice",
+		">CLIENT:ENV,END",
+		">CLIENT:DISCONNECT,3",
+		">CLIENT:ENV,END",
+	} {
+		_, err := middleware.ConsumeLine(line)
+		assert.NoError(t, err, line)
+	}
+
+	clients := middleware.Clients()
+	assert.Len(t, clients, 2)
+	assert.Equal(t, "bob", clients[1].Info().CommonName)
+
+	killed, err := middleware.Drain(func(c Client) bool {
+		return c.Env["common_name"] == "alice"
+	}, KillRestart, "maintenance")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, killed)
+	assert.Equal(t, []string{`client-kill 1 "RESTART,maintenance"`}, connection.WrittenLines)
+}
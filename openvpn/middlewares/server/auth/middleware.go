@@ -22,6 +22,7 @@ package auth
 import (
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/trevor403/go-openvpn-static/openvpn/log"
 	"github.com/trevor403/go-openvpn-static/openvpn/management"
@@ -31,6 +32,24 @@ import (
 // ClientEventCallback is called when state of each OpenVPN client changes.
 type ClientEventCallback func(event server.ClientEvent)
 
+// Decision describes an authorization verdict sent to OpenVPN for a client.
+type Decision struct {
+	Time      time.Time
+	ClientID  int
+	ClientKey int
+	Username  string
+	SourceIP  string
+	Accepted  bool
+	// Reason is the deny message, empty for accepted clients.
+	Reason string
+	// Latency is the time between the CONNECT or REAUTH request and the decision,
+	// zero when the request was not seen by this middleware.
+	Latency time.Duration
+}
+
+// DecisionCallback is called when authorization decision has been sent for a client.
+type DecisionCallback func(decision Decision)
+
 // Middleware is able to subscribe to client status events, exposes client control API.
 //
 // The OpenVPN server should have been started with the
@@ -42,6 +61,24 @@ type Middleware struct {
 
 	listenersMu sync.RWMutex
 	listeners   []ClientEventCallback
+	decisions   []DecisionCallback
+
+	pendingMu sync.Mutex
+	pending   map[pendingKey]pendingRequest
+	now       func() time.Time
+
+	clientsMu sync.RWMutex
+	clients   map[int]Client
+}
+
+type pendingKey struct {
+	clientID, keyID int
+}
+
+type pendingRequest struct {
+	username string
+	sourceIP string
+	received time.Time
 }
 
 // NewMiddleware creates new instance of Middleware.
@@ -49,6 +86,9 @@ func NewMiddleware(listeners ...ClientEventCallback) *Middleware {
 	return &Middleware{
 		currentEvent: server.UndefinedEvent(),
 		listeners:    listeners,
+		pending:      make(map[pendingKey]pendingRequest),
+		clients:      make(map[int]Client),
+		now:          time.Now,
 	}
 }
 
@@ -60,21 +100,55 @@ func (m *Middleware) ClientsSubscribe(callback ClientEventCallback) {
 	m.listeners = append(m.listeners, callback)
 }
 
+// DecisionSubscribe subscribes to authorization decisions sent by this middleware.
+func (m *Middleware) DecisionSubscribe(callback DecisionCallback) {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+
+	m.decisions = append(m.decisions, callback)
+}
+
 // ClientAccept is a client control which allows authorization (for CONNECT or REAUTH state).
 func (m *Middleware) ClientAccept(clientID, keyID int) error {
 	_, err := m.commandWriter.SingleLineCommand("client-auth-nt %d %d", clientID, keyID)
+	m.decided(err, Decision{ClientID: clientID, ClientKey: keyID, Accepted: true})
+	return err
+}
+
+// ClientAcceptWithConfig is a client control which allows authorization and passes client specific config lines,
+// i.e. push options (for CONNECT or REAUTH state).
+func (m *Middleware) ClientAcceptWithConfig(clientID, keyID int, config []string) error {
+	if len(config) == 0 {
+		return m.ClientAccept(clientID, keyID)
+	}
+	_, err := m.commandWriter.SingleLineCommand("client-auth %d %d\n%s\nEND", clientID, keyID, strings.Join(config, "\n"))
+	m.decided(err, Decision{ClientID: clientID, ClientKey: keyID, Accepted: true})
+	return err
+}
+
+// ClientPendingAuth is a client control which defers authorization (for CONNECT or REAUTH state),
+// i.e. "WEB_AUTH::url" extra asks the client to open the URL to finish authentication.
+// The client waits for client-auth or client-deny for the given timeout.
+func (m *Middleware) ClientPendingAuth(clientID, keyID int, extra string, timeout time.Duration) error {
+	_, err := m.commandWriter.SingleLineCommand("client-pending-auth %d %d %s %d", clientID, keyID, quote(extra), int(timeout/time.Second))
 	return err
 }
 
 // ClientDeny is a client control which forbids authorization (for CONNECT or REAUTH state).
 func (m *Middleware) ClientDeny(clientID, keyID int, message string) error {
-	_, err := m.commandWriter.SingleLineCommand("client-deny %d %d", clientID, keyID, message)
-	return err
+	return m.ClientDenyWithMessage(clientID, keyID, message)
 }
 
 // ClientDenyWithMessage is a client control which forbids authorization with reason message (for CONNECT or REAUTH state).
+// The message is logged by the server and sent to the client.
 func (m *Middleware) ClientDenyWithMessage(clientID, keyID int, message string) error {
-	_, err := m.commandWriter.SingleLineCommand("client-deny %d %d %s", clientID, keyID, message)
+	var err error
+	if message == "" {
+		_, err = m.commandWriter.SingleLineCommand("client-deny %d %d", clientID, keyID)
+	} else {
+		_, err = m.commandWriter.SingleLineCommand("client-deny %d %d %s %s", clientID, keyID, quote(message), quote(message))
+	}
+	m.decided(err, Decision{ClientID: clientID, ClientKey: keyID, Reason: message})
 	return err
 }
 
@@ -86,10 +160,45 @@ func (m *Middleware) ClientKill(clientID int) error {
 
 // ClientKillWithMessage is a client control which stops established connection with reason message (for ESTABLISHED state).
 func (m *Middleware) ClientKillWithMessage(clientID int, message string) error {
-	_, err := m.commandWriter.SingleLineCommand("client-kill %d %s", clientID, message)
+	_, err := m.commandWriter.SingleLineCommand("client-kill %d %s", clientID, quote(message))
 	return err
 }
 
+// ClientKillWithReason is a client control which stops established connection, telling the client
+// either to exit (HALT) or to reconnect (RESTART), optionally with reason message.
+// Returns the number of killed sessions, zero when the client is not connected.
+func (m *Middleware) ClientKillWithReason(clientID int, reason KillReason, message string) (int, error) {
+	kill := string(reason)
+	if message != "" {
+		kill += "," + message
+	}
+	_, err := m.commandWriter.SingleLineCommand("client-kill %d %s", clientID, quote(kill))
+	if err != nil {
+		return killNotFound(err)
+	}
+	return 1, nil
+}
+
+// KillCommonName stops all sessions of clients with the given certificate common name.
+// Returns the number of killed sessions.
+func (m *Middleware) KillCommonName(commonName string) (int, error) {
+	output, err := m.commandWriter.SingleLineCommand("kill %s", quote(commonName))
+	if err != nil {
+		return killNotFound(err)
+	}
+	return killedCount(output)
+}
+
+// KillAddress stops all sessions connected from the given real IPv4 address and port.
+// Returns the number of killed sessions.
+func (m *Middleware) KillAddress(ip string, port int) (int, error) {
+	output, err := m.commandWriter.SingleLineCommand("kill %s:%d", ip, port)
+	if err != nil {
+		return killNotFound(err)
+	}
+	return killedCount(output)
+}
+
 // Start starts the middleware.
 func (m *Middleware) Start(commandWriter management.CommandWriter) error {
 	m.commandWriter = commandWriter
@@ -138,8 +247,26 @@ func (m *Middleware) ConsumeLine(line string) (bool, error) {
 			return true, err
 		}
 		m.startOfEvent(eventType, ID, server.Undefined)
+	case server.CRResponse:
+		ID, key, response, err := server.ParseCRResponse(eventData)
+		if err != nil {
+			return true, err
+		}
+		m.startOfEvent(eventType, ID, key)
+		m.currentEvent.Response = response
 	case server.Address:
+		ID, address, err := server.ParseAddress(eventData)
+		if err != nil {
+			return true, err
+		}
 		log.Info("Address for client:", eventData)
+		m.notify(server.ClientEvent{
+			EventType: eventType,
+			ClientID:  ID,
+			ClientKey: server.Undefined,
+			Env:       make(map[string]string),
+			Address:   address,
+		})
 	default:
 		log.Error("Undefined user notification event:", eventType, eventData)
 		log.Error("Original line was:", line)
@@ -158,13 +285,64 @@ func (m *Middleware) addEnvVar(key string, val string) {
 }
 
 func (m *Middleware) endOfEvent() {
+	m.track(m.currentEvent)
+	m.trackClient(m.currentEvent)
+	m.notify(m.currentEvent)
+	m.reset()
+}
+
+func (m *Middleware) notify(event server.ClientEvent) {
 	m.listenersMu.RLock()
 	defer m.listenersMu.RUnlock()
 
 	for _, subscription := range m.listeners {
-		subscription(m.currentEvent)
+		subscription(event)
+	}
+}
+
+// track remembers authentication requests, so that decisions can be described with request details.
+func (m *Middleware) track(event server.ClientEvent) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+
+	switch event.EventType {
+	case server.Connect, server.Reauth:
+		m.pending[pendingKey{event.ClientID, event.ClientKey}] = pendingRequest{
+			username: event.Env["username"],
+			sourceIP: event.Env["untrusted_ip"],
+			received: m.now(),
+		}
+	case server.Disconnect:
+		for key := range m.pending {
+			if key.clientID == event.ClientID {
+				delete(m.pending, key)
+			}
+		}
+	}
+}
+
+func (m *Middleware) decided(err error, decision Decision) {
+	if err != nil {
+		return
+	}
+
+	decision.Time = m.now()
+	m.pendingMu.Lock()
+	key := pendingKey{decision.ClientID, decision.ClientKey}
+	if request, ok := m.pending[key]; ok {
+		delete(m.pending, key)
+		decision.Username = request.username
+		decision.SourceIP = request.sourceIP
+		decision.Latency = decision.Time.Sub(request.received)
+	}
+	m.pendingMu.Unlock()
+
+	m.listenersMu.RLock()
+	defer m.listenersMu.RUnlock()
+
+	for _, subscription := range m.decisions {
+		subscription(decision)
 	}
-	m.reset()
 }
 
 func (m *Middleware) reset() {
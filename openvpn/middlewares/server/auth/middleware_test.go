@@ -21,6 +21,7 @@ package auth
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/trevor403/go-openvpn-static/openvpn/management"
@@ -161,3 +162,123 @@ func Test_ConsumeLinesAcceptsClientIdsAntKeysWithSeveralDigits(t *testing.T) {
 		receivedEvent,
 	)
 }
+
+func Test_ClientAcceptWithConfig(t *testing.T) {
+	middleware := NewMiddleware()
+	mockConnection := &management.MockConnection{}
+	middleware.Start(mockConnection)
+
+	assert.NoError(t, middleware.ClientAcceptWithConfig(1, 2, []string{`push "route 10.0.0.0 255.0.0.0"`, "iroute 10.1.0.0 255.255.0.0"}))
+	assert.Equal(t, "client-auth 1 2\npush \"route 10.0.0.0 255.0.0.0\"\niroute 10.1.0.0 255.255.0.0\nEND", mockConnection.LastLine)
+
+	assert.NoError(t, middleware.ClientAcceptWithConfig(1, 2, nil))
+	assert.Equal(t, "client-auth-nt 1 2", mockConnection.LastLine)
+}
+
+func Test_ConsumeLineDeliversAddressEvent(t *testing.T) {
+	var receivedEvents []server.ClientEvent
+	middleware := NewMiddleware(func(e server.ClientEvent) {
+		receivedEvents = append(receivedEvents, e)
+	})
+
+	consumed, err := middleware.ConsumeLine(">CLIENT:ADDRESS,7,10.8.0.6,1")
+	assert.NoError(t, err)
+	assert.True(t, consumed)
+	assert.Equal(
+		t,
+		[]server.ClientEvent{{
+			EventType: server.Address,
+			ClientID:  7,
+			ClientKey: server.Undefined,
+			Env:       map[string]string{},
+			Address:   server.VirtualAddress{Address: "10.8.0.6", Primary: true},
+		}},
+		receivedEvents,
+	)
+
+	_, err = middleware.ConsumeLine(">CLIENT:ADDRESS,7,10.8.0.6")
+	assert.Error(t, err)
+}
+
+func Test_ConsumeLineDeliversCRResponseEvent(t *testing.T) {
+	var receivedEvent server.ClientEvent
+	middleware := NewMiddleware(func(e server.ClientEvent) {
+		receivedEvent = e
+	})
+
+	for _, line := range []string{
+		">CLIENT:CR_RESPONSE,3,4,MTIzNDU2",
+		">CLIENT:ENV,username=alice",
+		">CLIENT:ENV,END",
+	} {
+		consumed, err := middleware.ConsumeLine(line)
+		assert.NoError(t, err, line)
+		assert.True(t, consumed, line)
+	}
+
+	assert.Equal(
+		t,
+		server.ClientEvent{
+			EventType: server.CRResponse,
+			ClientID:  3,
+			ClientKey: 4,
+			Env:       map[string]string{"username": "alice"},
+			Response:  "123456",
+		},
+		receivedEvent,
+	)
+}
+
+func Test_DecisionSubscribeReceivesSentDecisions(t *testing.T) {
+	var decisions []Decision
+	clock := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	middleware := NewMiddleware()
+	middleware.now = func() time.Time { return clock }
+	middleware.DecisionSubscribe(func(d Decision) {
+		decisions = append(decisions, d)
+	})
+	middleware.Start(&management.MockConnection{})
+
+	for _, line := range []string{
+		">CLIENT:CONNECT,4,1",
+		">CLIENT:ENV,username=alice",
+		">CLIENT:ENV,untrusted_ip=192.0.2.10",
+		">CLIENT:ENV,END",
+	} {
+		_, err := middleware.ConsumeLine(line)
+		assert.NoError(t, err, line)
+	}
+	clock = clock.Add(150 * time.Millisecond)
+
+	assert.NoError(t, middleware.ClientAccept(1, 2))
+	assert.NoError(t, middleware.ClientAcceptWithConfig(3, 0, []string{`push "route 10.0.0.0"`}))
+	assert.NoError(t, middleware.ClientDenyWithMessage(4, 1, "wrong username or password"))
+
+	assert.Equal(
+		t,
+		[]Decision{
+			{Time: clock, ClientID: 1, ClientKey: 2, Accepted: true},
+			{Time: clock, ClientID: 3, ClientKey: 0, Accepted: true},
+			{
+				Time:      clock,
+				ClientID:  4,
+				ClientKey: 1,
+				Username:  "alice",
+				SourceIP:  "192.0.2.10",
+				Reason:    "wrong username or password",
+				Latency:   150 * time.Millisecond,
+			},
+		},
+		decisions,
+	)
+	assert.Empty(t, middleware.pending)
+}
+
+func Test_ClientPendingAuth(t *testing.T) {
+	connection := &management.MockConnection{}
+	middleware := NewMiddleware()
+	middleware.Start(connection)
+
+	assert.NoError(t, middleware.ClientPendingAuth(1, 2, "WEB_AUTH::https://example.com/login?state=abc", 2*time.Minute))
+	assert.Equal(t, `client-pending-auth 1 2 "WEB_AUTH::https://example.com/login?state=abc" 120`, connection.LastLine)
+}
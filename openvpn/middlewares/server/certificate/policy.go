@@ -0,0 +1,170 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certificate
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/credentials"
+)
+
+var (
+	// ErrCommonNameNotAllowed is returned when client common name is not in the allow-list.
+	ErrCommonNameNotAllowed = errors.New("certificate not allowed")
+	// ErrSerialDenied is returned when client certificate serial is in the deny-list.
+	ErrSerialDenied = errors.New("certificate revoked")
+	// ErrIssuerNotPinned is returned when client certificate is issued by not pinned CA.
+	ErrIssuerNotPinned = errors.New("untrusted certificate issuer")
+	// ErrUsernameMismatch is returned when username differs from client common name.
+	ErrUsernameMismatch = errors.New("username does not match certificate")
+	// ErrInvalidPolicy is returned for every client when the policy is invalid, i.e. it has unparsable denied serials.
+	ErrInvalidPolicy = errors.New("invalid certificate policy")
+)
+
+// Policy authorizes clients by attributes of their certificates.
+// Empty fields don't restrict anything.
+type Policy struct {
+	// AllowedCommonNames lists common names which are allowed to connect.
+	AllowedCommonNames []string
+	// DeniedSerials lists revoked certificate serials, either decimal, colon separated hex or 0x prefixed hex.
+	DeniedSerials []string
+	// IssuerDigests pins SHA1 or SHA256 fingerprints of the CA which issued client certificate.
+	IssuerDigests []string
+	// UsernameMatchesCN requires the username to be equal to client common name.
+	UsernameMatchesCN bool
+}
+
+// NewMiddleware creates credentials middleware which authorizes clients by the policy first
+// and validates their username and password afterwards. Invalid policy is rejected.
+// Validator may be nil to authorize clients by certificate only.
+func NewMiddleware(policy Policy, validator credentials.Validator) (*credentials.Middleware, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return credentials.NewMiddleware(validator, policy.Check), nil
+}
+
+// Validate checks that all denied serials can be parsed.
+func (p Policy) Validate() error {
+	for _, denied := range p.DeniedSerials {
+		if _, ok := parseSerial(denied); !ok {
+			return fmt.Errorf("invalid denied serial %q", denied)
+		}
+	}
+	return nil
+}
+
+// Check authorizes client event by certificate attributes, it conforms to credentials.Check callback.
+func (p Policy) Check(event server.ClientEvent) error {
+	err := p.check(event.Env)
+	if err != nil {
+		log.Warn("Certificate policy rejected client with ID:", event.ClientID, "common name:", event.Env["common_name"], err)
+	} else {
+		log.Debug("Certificate policy accepted client with ID:", event.ClientID, "common name:", event.Env["common_name"])
+	}
+	return err
+}
+
+func (p Policy) check(env map[string]string) error {
+	commonName := env["common_name"]
+
+	if len(p.AllowedCommonNames) > 0 && !contains(p.AllowedCommonNames, commonName) {
+		return ErrCommonNameNotAllowed
+	}
+
+	if len(p.DeniedSerials) > 0 {
+		serial, ok := clientSerial(env)
+		if !ok {
+			return ErrSerialDenied
+		}
+		for _, denied := range p.DeniedSerials {
+			deniedSerial, ok := parseSerial(denied)
+			if !ok {
+				// fail closed, a revoked certificate must not get in because of a typo
+				return ErrInvalidPolicy
+			}
+			if deniedSerial.Cmp(serial) == 0 {
+				return ErrSerialDenied
+			}
+		}
+	}
+
+	if len(p.IssuerDigests) > 0 && !p.issuerPinned(env) {
+		return ErrIssuerNotPinned
+	}
+
+	if p.UsernameMatchesCN && env["username"] != commonName {
+		return ErrUsernameMismatch
+	}
+
+	return nil
+}
+
+func (p Policy) issuerPinned(env map[string]string) bool {
+	for _, key := range []string{"tls_digest_1", "tls_digest_sha256_1"} {
+		digest := normalizeDigest(env[key])
+		if digest == "" {
+			continue
+		}
+		for _, pinned := range p.IssuerDigests {
+			if normalizeDigest(pinned) == digest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func clientSerial(env map[string]string) (*big.Int, bool) {
+	if serial, ok := new(big.Int).SetString(env["tls_serial_0"], 10); ok {
+		return serial, true
+	}
+	return parseSerial(env["tls_serial_hex_0"])
+}
+
+func parseSerial(serial string) (*big.Int, bool) {
+	serial = strings.TrimSpace(serial)
+	if serial == "" {
+		return nil, false
+	}
+	if strings.Contains(serial, ":") || strings.HasPrefix(strings.ToLower(serial), "0x") {
+		serial = strings.TrimPrefix(strings.ToLower(serial), "0x")
+		return new(big.Int).SetString(strings.Replace(serial, ":", "", -1), 16)
+	}
+	return new(big.Int).SetString(serial, 10)
+}
+
+func normalizeDigest(digest string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(digest), ":", "", -1))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package certificate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+func clientEnv() map[string]string {
+	return map[string]string{
+		"common_name":         "alice",
+		"username":            "alice",
+		"password":            "secret",
+		"tls_serial_0":        "4660",
+		"tls_serial_hex_0":    "12:34",
+		"tls_digest_1":        "AA:BB:CC",
+		"tls_digest_sha256_1": "dd:ee:ff",
+	}
+}
+
+func Test_PolicyCheck(t *testing.T) {
+	var tests = []struct {
+		policy   Policy
+		expected error
+	}{
+		{Policy{}, nil},
+		{Policy{AllowedCommonNames: []string{"bob", "alice"}}, nil},
+		{Policy{AllowedCommonNames: []string{"bob"}}, ErrCommonNameNotAllowed},
+		{Policy{DeniedSerials: []string{"1", "0x1235"}}, nil},
+		{Policy{DeniedSerials: []string{"4660"}}, ErrSerialDenied},
+		{Policy{DeniedSerials: []string{"12:34"}}, ErrSerialDenied},
+		{Policy{DeniedSerials: []string{"1", "1234abcd"}}, ErrInvalidPolicy},
+		{Policy{IssuerDigests: []string{"00:11"}}, ErrIssuerNotPinned},
+		{Policy{IssuerDigests: []string{"aabbcc"}}, nil},
+		{Policy{IssuerDigests: []string{"DD:EE:FF"}}, nil},
+		{Policy{UsernameMatchesCN: true}, nil},
+	}
+
+	for _, test := range tests {
+		err := test.policy.Check(server.ClientEvent{EventType: server.Connect, Env: clientEnv()})
+		assert.Equal(t, test.expected, err, "%+v", test.policy)
+	}
+
+	env := clientEnv()
+	env["username"] = "bob"
+	err := Policy{UsernameMatchesCN: true}.Check(server.ClientEvent{EventType: server.Connect, Env: env})
+	assert.Equal(t, ErrUsernameMismatch, err)
+}
+
+func Test_PolicyValidate(t *testing.T) {
+	assert.NoError(t, Policy{DeniedSerials: []string{"4660", "12:34", "0x1234"}}.Validate())
+	assert.EqualError(t, Policy{DeniedSerials: []string{"4660", "1234abcd"}}.Validate(), `invalid denied serial "1234abcd"`)
+
+	middleware, err := NewMiddleware(Policy{DeniedSerials: []string{""}}, nil)
+	assert.Error(t, err)
+	assert.Nil(t, middleware)
+}
+
+func Test_MiddlewareWithoutValidator(t *testing.T) {
+	mockConnection := &management.MockConnection{}
+	middleware, err := NewMiddleware(Policy{AllowedCommonNames: []string{"alice"}}, nil)
+	require.NoError(t, err)
+	middleware.Start(mockConnection)
+
+	middleware.ConsumeLine(">CLIENT:CONNECT,1,2")
+	middleware.ConsumeLine(">CLIENT:ENV,common_name=alice")
+	middleware.ConsumeLine(">CLIENT:ENV,END")
+	assert.Equal(t, "client-auth-nt 1 2", mockConnection.LastLine)
+
+	middleware.ConsumeLine(">CLIENT:CONNECT,3,4")
+	middleware.ConsumeLine(">CLIENT:ENV,common_name=mallory")
+	middleware.ConsumeLine(">CLIENT:ENV,END")
+	assert.Equal(t, `client-deny 3 4 "certificate not allowed" "certificate not allowed"`, mockConnection.LastLine)
+}
+
+func Test_MiddlewareWithValidator(t *testing.T) {
+	mockConnection := &management.MockConnection{}
+	validator := func(_ int, username, password string) (bool, error) {
+		return password == "secret", nil
+	}
+	middleware, err := NewMiddleware(Policy{UsernameMatchesCN: true}, validator)
+	require.NoError(t, err)
+	middleware.Start(mockConnection)
+
+	middleware.ConsumeLine(">CLIENT:CONNECT,1,2")
+	middleware.ConsumeLine(">CLIENT:ENV,common_name=alice")
+	middleware.ConsumeLine(">CLIENT:ENV,username=alice")
+	middleware.ConsumeLine(">CLIENT:ENV,password=wrong")
+	middleware.ConsumeLine(">CLIENT:ENV,END")
+	assert.Equal(t, `client-deny 1 2 "wrong username or password" "wrong username or password"`, mockConnection.LastLine)
+
+	middleware.ConsumeLine(">CLIENT:CONNECT,1,3")
+	middleware.ConsumeLine(">CLIENT:ENV,common_name=alice")
+	middleware.ConsumeLine(">CLIENT:ENV,username=alice")
+	middleware.ConsumeLine(">CLIENT:ENV,password=secret")
+	middleware.ConsumeLine(">CLIENT:ENV,END")
+	assert.Equal(t, "client-auth-nt 1 3", mockConnection.LastLine)
+}
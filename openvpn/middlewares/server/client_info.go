@@ -0,0 +1,200 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Platform is a client operating system reported by IV_PLAT peer info.
+type Platform string
+
+const (
+	// PlatformUnknown means that client did not report its platform.
+	PlatformUnknown = Platform("")
+	// PlatformLinux represents Linux clients.
+	PlatformLinux = Platform("linux")
+	// PlatformWindows represents Windows clients.
+	PlatformWindows = Platform("win")
+	// PlatformMac represents macOS clients.
+	PlatformMac = Platform("mac")
+	// PlatformAndroid represents Android clients.
+	PlatformAndroid = Platform("android")
+	// PlatformIOS represents iOS clients.
+	PlatformIOS = Platform("ios")
+	// PlatformFreeBSD represents FreeBSD clients.
+	PlatformFreeBSD = Platform("freebsd")
+	// PlatformOpenBSD represents OpenBSD clients.
+	PlatformOpenBSD = Platform("openbsd")
+	// PlatformNetBSD represents NetBSD clients.
+	PlatformNetBSD = Platform("netbsd")
+	// PlatformSolaris represents Solaris clients.
+	PlatformSolaris = Platform("solaris")
+)
+
+// ProtocolFlags are protocol capabilities reported by IV_PROTO peer info.
+type ProtocolFlags uint
+
+const (
+	// ProtoDataV2 means that client supports P_DATA_V2 packets.
+	ProtoDataV2 ProtocolFlags = 1 << (iota + 1)
+	// ProtoRequestPush means that client requests push without waiting.
+	ProtoRequestPush
+	// ProtoTLSKeyExport means that client supports key derivation by TLS keying material exporter.
+	ProtoTLSKeyExport
+	// ProtoAuthPendingKeywords means that client understands pending auth keywords.
+	ProtoAuthPendingKeywords
+	// ProtoNCPP2P means that client supports cipher negotiation in peer-to-peer mode.
+	ProtoNCPP2P
+	// ProtoDNSOption means that client supports dns option.
+	ProtoDNSOption
+	// ProtoExitNotify means that client supports exit notification via control channel.
+	ProtoExitNotify
+	// ProtoAuthFailTemp means that client understands temporary authentication failures.
+	ProtoAuthFailTemp
+	// ProtoDynamicTLSCrypt means that client supports dynamic tls-crypt keys.
+	ProtoDynamicTLSCrypt
+)
+
+// Has tells whether all given flags are set.
+func (f ProtocolFlags) Has(flags ProtocolFlags) bool {
+	return f&flags == flags
+}
+
+// Version is a client version reported by IV_VER peer info, i.e. 2.4.9 or 3.git::58b92569.
+type Version struct {
+	Major, Minor, Patch int
+	Raw                 string
+}
+
+// ParseVersion parses client version string, trailing non numeric suffixes are ignored.
+func ParseVersion(raw string) (Version, error) {
+	version := Version{Raw: raw}
+	parts := strings.SplitN(raw, ".", 3)
+	numbers := []*int{&version.Major, &version.Minor, &version.Patch}
+
+	for i, part := range parts {
+		digits := part
+		if end := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+			digits = part[:end]
+		}
+		if digits == "" {
+			if i == 0 {
+				return version, fmt.Errorf("invalid version: %q", raw)
+			}
+			break
+		}
+		number, err := strconv.Atoi(digits)
+		if err != nil {
+			return version, fmt.Errorf("invalid version: %q", raw)
+		}
+		*numbers[i] = number
+		if len(digits) != len(part) {
+			break
+		}
+	}
+	return version, nil
+}
+
+// Compare returns -1, 0 or 1 when version is lower, equal or greater than other.
+func (v Version) Compare(other Version) int {
+	for _, pair := range [][2]int{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if pair[0] < pair[1] {
+			return -1
+		}
+		if pair[0] > pair[1] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// AtLeast tells whether version is equal or greater than other.
+func (v Version) AtLeast(other Version) bool {
+	return v.Compare(other) >= 0
+}
+
+// String returns dotted version representation.
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// ClientInfo is a typed representation of client environment and peer info.
+type ClientInfo struct {
+	CommonName string
+	Username   string
+
+	RemoteIP   net.IP
+	RemotePort int
+
+	Version    Version
+	Platform   Platform
+	Protocol   ProtocolFlags
+	Ciphers    []string
+	GUIVersion string
+	SSO        []string
+}
+
+// ParseClientInfo builds client info from client environment.
+// Malformed values are left empty, as clients are free to report anything in their peer info.
+func ParseClientInfo(env map[string]string) ClientInfo {
+	info := ClientInfo{
+		CommonName: env["common_name"],
+		Username:   env["username"],
+		Platform:   Platform(strings.ToLower(env["IV_PLAT"])),
+		GUIVersion: env["IV_GUI_VER"],
+		Ciphers:    splitList(env["IV_CIPHERS"], ":"),
+		SSO:        splitList(env["IV_SSO"], ","),
+	}
+
+	info.RemoteIP = net.ParseIP(env["untrusted_ip"])
+	if info.RemoteIP == nil {
+		info.RemoteIP = net.ParseIP(env["untrusted_ip6"])
+	}
+	if port, err := strconv.Atoi(env["untrusted_port"]); err == nil && port > 0 && port <= 65535 {
+		info.RemotePort = port
+	}
+	if version, err := ParseVersion(env["IV_VER"]); err == nil {
+		info.Version = version
+	}
+	if proto, err := strconv.ParseUint(env["IV_PROTO"], 10, 32); err == nil {
+		info.Protocol = ProtocolFlags(proto)
+	}
+
+	return info
+}
+
+// Info returns typed client info of the event.
+func (e ClientEvent) Info() ClientInfo {
+	return ParseClientInfo(e.Env)
+}
+
+func splitList(value, separator string) []string {
+	var items []string
+	for _, item := range strings.Split(value, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
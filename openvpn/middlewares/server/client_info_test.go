@@ -0,0 +1,105 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseVersion(t *testing.T) {
+	var tests = []struct {
+		raw      string
+		expected Version
+		err      bool
+	}{
+		{"2.4.9", Version{2, 4, 9, "2.4.9"}, false},
+		{"2.6_git", Version{2, 6, 0, "2.6_git"}, false},
+		{"2.5.1-I601", Version{2, 5, 1, "2.5.1-I601"}, false},
+		{"3.git::58b92569", Version{3, 0, 0, "3.git::58b92569"}, false},
+		{"", Version{Raw: ""}, true},
+		{"git", Version{Raw: "git"}, true},
+	}
+
+	for _, test := range tests {
+		version, err := ParseVersion(test.raw)
+		assert.Equal(t, test.expected, version, test.raw)
+		assert.Equal(t, test.err, err != nil, test.raw)
+	}
+}
+
+func Test_VersionCompare(t *testing.T) {
+	v249, _ := ParseVersion("2.4.9")
+	v25, _ := ParseVersion("2.5")
+	assert.True(t, v25.AtLeast(v249))
+	assert.False(t, v249.AtLeast(v25))
+	assert.True(t, v249.AtLeast(v249))
+	assert.Equal(t, "2.5.0", v25.String())
+}
+
+func Test_ParseClientInfo(t *testing.T) {
+	event := ClientEvent{
+		EventType: Connect,
+		Env: map[string]string{
+			"common_name":    "alice-laptop",
+			"username":       "alice",
+			"untrusted_ip":   "1.2.3.4",
+			"untrusted_port": "51820",
+			"IV_VER":         "2.5.1",
+			"IV_PLAT":        "Win",
+			"IV_PROTO":       "30",
+			"IV_CIPHERS":     "AES-256-GCM:AES-128-GCM:CHACHA20-POLY1305",
+			"IV_GUI_VER":     "OpenVPN_GUI_11",
+			"IV_SSO":         "webauth,openurl",
+		},
+	}
+
+	info := event.Info()
+	assert.Equal(t, ClientInfo{
+		CommonName: "alice-laptop",
+		Username:   "alice",
+		RemoteIP:   net.ParseIP("1.2.3.4"),
+		RemotePort: 51820,
+		Version:    Version{2, 5, 1, "2.5.1"},
+		Platform:   PlatformWindows,
+		Protocol:   ProtoDataV2 | ProtoRequestPush | ProtoTLSKeyExport | ProtoAuthPendingKeywords,
+		Ciphers:    []string{"AES-256-GCM", "AES-128-GCM", "CHACHA20-POLY1305"},
+		GUIVersion: "OpenVPN_GUI_11",
+		SSO:        []string{"webauth", "openurl"},
+	}, info)
+	assert.True(t, info.Protocol.Has(ProtoDataV2|ProtoTLSKeyExport))
+	assert.False(t, info.Protocol.Has(ProtoDNSOption))
+}
+
+func Test_ParseClientInfoIgnoresMalformedValues(t *testing.T) {
+	info := ParseClientInfo(map[string]string{
+		"untrusted_ip6":  "2001:db8::1",
+		"untrusted_port": "99999",
+		"IV_VER":         "unknown",
+		"IV_PROTO":       "-1",
+	})
+	assert.Equal(t, net.ParseIP("2001:db8::1"), info.RemoteIP)
+	assert.Equal(t, 0, info.RemotePort)
+	assert.Equal(t, Version{}, info.Version)
+	assert.Equal(t, ProtocolFlags(0), info.Protocol)
+	assert.Nil(t, info.Ciphers)
+}
@@ -0,0 +1,427 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ldapauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+const (
+	defaultTimeout        = 5 * time.Second
+	defaultPoolSize       = 4
+	defaultGroupAttribute = "cn"
+	// clientConfigTTL bounds how long config of a validated client waits for ClientConfig,
+	// it is never asked for when a later check denies the client.
+	clientConfigTTL = time.Minute
+)
+
+// Config configures LDAP authentication.
+//
+// User DN is either built from UserDNTemplate, i.e. "uid=%s,ou=people,dc=example,dc=com",
+// or searched for with UserFilter, i.e. "(uid=%s)", under BaseDN using the BindDN service account.
+type Config struct {
+	// URL of the server, "ldaps://" or "ldap://".
+	URL string
+	// StartTLS upgrades "ldap://" connections with StartTLS.
+	StartTLS  bool
+	TLSConfig *tls.Config
+	// Timeout of dialing and each LDAP operation, 5 seconds by default.
+	Timeout time.Duration
+
+	UserDNTemplate string
+
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string
+
+	// GroupFilter finds groups of the user under GroupBaseDN, %s is replaced with user DN,
+	// i.e. "(&(objectClass=groupOfNames)(member=%s))". Empty filter disables group lookup.
+	GroupFilter    string
+	GroupBaseDN    string
+	GroupAttribute string
+	// RequiredGroups lists groups, at least one of which the user must be member of. Empty allows everyone.
+	RequiredGroups []string
+	// GroupConfig maps group names to client specific config lines, i.e. push options.
+	GroupConfig map[string][]string
+
+	// PoolSize is the number of idle connections kept open, 4 by default.
+	PoolSize int
+	// CacheTTL keeps successful authentication results for given time, zero disables caching.
+	CacheTTL time.Duration
+}
+
+// Result describes the authenticated user.
+type Result struct {
+	DN     string
+	Groups []string
+}
+
+type cached struct {
+	result  Result
+	expires time.Time
+}
+
+type pending struct {
+	config  []string
+	expires time.Time
+}
+
+// Validator authenticates users with LDAP simple bind.
+type Validator struct {
+	config Config
+	dial   func() (*ldap.Conn, error)
+	now    func() time.Time
+
+	pool chan *ldap.Conn
+
+	cacheKey []byte
+	mu       sync.Mutex
+	cache    map[string]cached
+	configs  map[int]pending
+}
+
+// NewValidator creates LDAP credentials validator.
+func NewValidator(config Config) (*Validator, error) {
+	if config.UserDNTemplate == "" && config.UserFilter == "" {
+		return nil, errors.New("either user DN template or user filter is required")
+	}
+	if config.UserFilter != "" && config.BaseDN == "" {
+		return nil, errors.New("base DN is required to search for users")
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("unsupported LDAP URL scheme: %q", u.Scheme)
+	}
+	if config.TLSConfig == nil {
+		config.TLSConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.PoolSize <= 0 {
+		config.PoolSize = defaultPoolSize
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = defaultGroupAttribute
+	}
+	if config.GroupBaseDN == "" {
+		config.GroupBaseDN = config.BaseDN
+	}
+
+	cacheKey := make([]byte, 32)
+	if _, err := rand.Read(cacheKey); err != nil {
+		return nil, err
+	}
+	v := &Validator{
+		config:   config,
+		now:      time.Now,
+		pool:     make(chan *ldap.Conn, config.PoolSize),
+		cacheKey: cacheKey,
+		cache:    make(map[string]cached),
+		configs:  make(map[int]pending),
+	}
+	v.dial = v.connect
+	return v, nil
+}
+
+// Validate checks given credentials, it conforms to credentials.Validator callback.
+// Client config of the user groups is remembered for ClientConfig.
+func (v *Validator) Validate(clientID int, username, password string) (bool, error) {
+	result, ok, err := v.Authenticate(username, password)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	config := v.groupConfig(result.Groups)
+	v.mu.Lock()
+	now := v.now()
+	for id, p := range v.configs {
+		if !now.Before(p.expires) {
+			delete(v.configs, id)
+		}
+	}
+	if len(config) > 0 {
+		v.configs[clientID] = pending{config: config, expires: now.Add(clientConfigTTL)}
+	} else {
+		delete(v.configs, clientID)
+	}
+	v.mu.Unlock()
+	return true, nil
+}
+
+// ClientConfig returns config lines of groups of the client validated last, it conforms to
+// credentials.ConfigProvider callback.
+func (v *Validator) ClientConfig(clientID int, _ server.ClientInfo) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	p, ok := v.configs[clientID]
+	delete(v.configs, clientID)
+	if !ok || !v.now().Before(p.expires) {
+		return nil
+	}
+	return p.config
+}
+
+// Authenticate binds as the user and looks up user groups.
+// Wrong credentials and missing required group membership are reported as not ok, without an error.
+func (v *Validator) Authenticate(username, password string) (Result, bool, error) {
+	if username == "" || password == "" {
+		return Result{}, false, nil
+	}
+
+	key := v.key(username, password)
+	if result, ok := v.cached(key); ok {
+		return result, true, nil
+	}
+
+	conn, err := v.get()
+	if err != nil {
+		return Result{}, false, err
+	}
+	result, ok, err := v.authenticate(conn, username, password)
+	if err == nil && result.DN != "" && v.config.BindDN == "" {
+		// without service account the next user of the pooled connection would act as this user
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return Result{}, false, err
+	}
+	v.put(conn)
+
+	if ok && v.config.CacheTTL > 0 {
+		v.mu.Lock()
+		now := v.now()
+		for k, entry := range v.cache {
+			if !now.Before(entry.expires) {
+				delete(v.cache, k)
+			}
+		}
+		v.cache[key] = cached{result: result, expires: now.Add(v.config.CacheTTL)}
+		v.mu.Unlock()
+	}
+	return result, ok, nil
+}
+
+// Close closes idle connections.
+func (v *Validator) Close() {
+	for {
+		select {
+		case conn := <-v.pool:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (v *Validator) authenticate(conn *ldap.Conn, username, password string) (Result, bool, error) {
+	dn, found, err := v.userDN(conn, username)
+	if err != nil || !found {
+		return Result{}, false, err
+	}
+
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Result{}, false, nil
+		}
+		return Result{}, false, err
+	}
+
+	result := Result{DN: dn}
+	if v.config.GroupFilter == "" {
+		return result, true, nil
+	}
+	if err := v.serviceBind(conn); err != nil {
+		return Result{}, false, err
+	}
+	search := ldap.NewSearchRequest(
+		v.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(v.config.Timeout/time.Second), false,
+		fmt.Sprintf(v.config.GroupFilter, ldap.EscapeFilter(dn)),
+		[]string{v.config.GroupAttribute},
+		nil,
+	)
+	groups, err := conn.Search(search)
+	if err != nil {
+		return Result{}, false, err
+	}
+	for _, entry := range groups.Entries {
+		result.Groups = append(result.Groups, entry.GetAttributeValues(v.config.GroupAttribute)...)
+	}
+	return result, v.allowed(result.Groups), nil
+}
+
+func (v *Validator) userDN(conn *ldap.Conn, username string) (string, bool, error) {
+	if v.config.UserDNTemplate != "" {
+		return fmt.Sprintf(v.config.UserDNTemplate, escapeDN(username)), true, nil
+	}
+
+	if err := v.serviceBind(conn); err != nil {
+		return "", false, err
+	}
+	search := ldap.NewSearchRequest(
+		v.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(v.config.Timeout/time.Second), false,
+		fmt.Sprintf(v.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn"},
+		nil,
+	)
+	users, err := conn.Search(search)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if len(users.Entries) != 1 {
+		return "", false, nil
+	}
+	return users.Entries[0].DN, true, nil
+}
+
+// serviceBind binds as the service account, when there is no account operations use the user identity.
+func (v *Validator) serviceBind(conn *ldap.Conn) error {
+	if v.config.BindDN == "" {
+		return nil
+	}
+	return conn.Bind(v.config.BindDN, v.config.BindPassword)
+}
+
+func (v *Validator) allowed(groups []string) bool {
+	if len(v.config.RequiredGroups) == 0 {
+		return true
+	}
+	for _, required := range v.config.RequiredGroups {
+		for _, group := range groups {
+			if strings.EqualFold(required, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *Validator) groupConfig(groups []string) []string {
+	var config []string
+	for _, group := range groups {
+		config = append(config, v.config.GroupConfig[group]...)
+	}
+	return config
+}
+
+func (v *Validator) key(username, password string) string {
+	mac := hmac.New(sha256.New, v.cacheKey)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return string(mac.Sum(nil))
+}
+
+func (v *Validator) cached(key string) (Result, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, ok := v.cache[key]
+	if !ok {
+		return Result{}, false
+	}
+	if !v.now().Before(entry.expires) {
+		delete(v.cache, key)
+		return Result{}, false
+	}
+	return entry.result, true
+}
+
+func (v *Validator) get() (*ldap.Conn, error) {
+	for {
+		select {
+		case conn := <-v.pool:
+			if conn.IsClosing() {
+				continue
+			}
+			return conn, nil
+		default:
+			return v.dial()
+		}
+	}
+}
+
+func (v *Validator) put(conn *ldap.Conn) {
+	select {
+	case v.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (v *Validator) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(
+		v.config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: v.config.Timeout}),
+		ldap.DialWithTLSConfig(v.config.TLSConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(v.config.Timeout)
+	if v.config.StartTLS {
+		if err := conn.StartTLS(v.config.TLSConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// escapeDN escapes attribute value of a distinguished name as defined by RFC 4514 section 2.4.
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`"+,;<>\=`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(value)-1 && r == ' ':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
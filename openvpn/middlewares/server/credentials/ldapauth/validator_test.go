@@ -0,0 +1,416 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ldapauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+const (
+	resultSuccess             = 0
+	resultSizeLimitExceeded   = 4
+	resultInvalidCredentials  = 49
+	startTLSOID               = "1.3.6.1.4.1.1466.20037"
+	applicationExtendedResult = 24
+)
+
+// standIn is a minimal in-process LDAP server supporting simple bind, search with
+// and/or/not/equality/present filters and StartTLS.
+type standIn struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	entries   map[string]map[string][]string
+	passwords map[string]string
+
+	mu          sync.Mutex
+	binds       []string
+	connections int
+}
+
+var directory = map[string]map[string][]string{
+	"uid=alice,ou=people,dc=example,dc=com": {"uid": {"alice"}, "objectclass": {"person"}},
+	"uid=bob,ou=people,dc=example,dc=com":   {"uid": {"bob"}, "objectclass": {"person"}},
+	"cn=vpn,ou=groups,dc=example,dc=com": {
+		"cn":          {"vpn"},
+		"objectclass": {"groupOfNames"},
+		"member":      {"uid=alice,ou=people,dc=example,dc=com"},
+	},
+	"cn=admins,ou=groups,dc=example,dc=com": {
+		"cn":          {"admins"},
+		"objectclass": {"groupOfNames"},
+		"member":      {"uid=alice,ou=people,dc=example,dc=com"},
+	},
+}
+
+var passwords = map[string]string{
+	"uid=alice,ou=people,dc=example,dc=com": "alice-secret",
+	"uid=bob,ou=people,dc=example,dc=com":   "bob-secret",
+	"cn=service,dc=example,dc=com":          "service-secret",
+}
+
+func newStandIn(t *testing.T, ldaps bool) (*standIn, *tls.Config) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if ldaps {
+		listener = tls.NewListener(listener, serverTLS)
+	}
+	s := &standIn{listener: listener, tlsConfig: serverTLS, entries: directory, passwords: passwords}
+	go s.serve()
+	return s, clientTLS
+}
+
+func (s *standIn) url(scheme string) string {
+	return scheme + "://" + s.listener.Addr().String()
+}
+
+func (s *standIn) close() {
+	s.listener.Close()
+}
+
+func (s *standIn) stats() ([]string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.binds...), s.connections
+}
+
+func (s *standIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.connections++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *standIn) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			s.mu.Lock()
+			s.binds = append(s.binds, dn)
+			s.mu.Unlock()
+			code := int64(resultInvalidCredentials)
+			if expected, ok := s.passwords[dn]; ok && expected == password || dn == "" && password == "" {
+				code = resultSuccess
+			}
+			s.reply(conn, id, result(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationSearchRequest:
+			s.search(conn, id, op)
+		case ldap.ApplicationExtendedRequest:
+			s.reply(conn, id, result(applicationExtendedResult, resultSuccess))
+			if op.Children[0].Data.String() == startTLSOID {
+				conn = tls.Server(conn, s.tlsConfig)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *standIn) search(conn net.Conn, id int64, op *ber.Packet) {
+	base := strings.ToLower(op.Children[0].Value.(string))
+	sizeLimit := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+
+	var found int64
+	for dn, attributes := range s.entries {
+		if !strings.HasSuffix(dn, base) || !matches(filter, attributes) {
+			continue
+		}
+		found++
+		if sizeLimit > 0 && found > sizeLimit {
+			s.reply(conn, id, result(ldap.ApplicationSearchResultDone, resultSizeLimitExceeded))
+			return
+		}
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+		list := ber.NewSequence("")
+		for name, values := range attributes {
+			attribute := ber.NewSequence("")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+			attribute.AppendChild(set)
+			list.AppendChild(attribute)
+		}
+		entry.AppendChild(list)
+		s.reply(conn, id, entry)
+	}
+	s.reply(conn, id, result(ldap.ApplicationSearchResultDone, resultSuccess))
+}
+
+func matches(filter *ber.Packet, attributes map[string][]string) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, attributes) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(child, attributes) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matches(filter.Children[0], attributes)
+	case ldap.FilterEqualityMatch:
+		name := strings.ToLower(filter.Children[0].Data.String())
+		value := filter.Children[1].Data.String()
+		for _, candidate := range attributes[name] {
+			if strings.EqualFold(candidate, value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		_, ok := attributes[strings.ToLower(filter.Data.String())]
+		return ok
+	}
+	return false
+}
+
+func result(application ber.Tag, code int64) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, application, nil, "")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return response
+}
+
+func (s *standIn) reply(conn net.Conn, id int64, op *ber.Packet) {
+	envelope := ber.NewSequence("")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	envelope.AppendChild(op)
+	conn.Write(envelope.Bytes())
+}
+
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ldap stand-in"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	return server, client
+}
+
+func TestDNTemplateBindOverLDAPSWithGroupConfig(t *testing.T) {
+	s, clientTLS := newStandIn(t, true)
+	defer s.close()
+
+	v, err := NewValidator(Config{
+		URL:            s.url("ldaps"),
+		TLSConfig:      clientTLS,
+		UserDNTemplate: "uid=%s,ou=people,dc=example,dc=com",
+		GroupFilter:    "(&(objectClass=groupOfNames)(member=%s))",
+		GroupBaseDN:    "ou=groups,dc=example,dc=com",
+		GroupConfig: map[string][]string{
+			"admins": {`push "route 10.10.0.0 255.255.0.0"`},
+		},
+	})
+	require.NoError(t, err)
+	defer v.Close()
+
+	valid, err := v.Validate(1, "alice", "alice-secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, []string{`push "route 10.10.0.0 255.255.0.0"`}, v.ClientConfig(1, server.ClientInfo{Username: "alice"}))
+	assert.Empty(t, v.ClientConfig(1, server.ClientInfo{Username: "alice"}), "config is handed out once")
+
+	valid, err = v.Validate(2, "alice", "wrong")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	valid, err = v.Validate(3, "alice,ou=people,dc=example,dc=com", "alice-secret")
+	assert.NoError(t, err)
+	assert.False(t, valid, "DN injection")
+}
+
+func TestClientConfigOfDeniedClientsExpires(t *testing.T) {
+	s, clientTLS := newStandIn(t, true)
+	defer s.close()
+
+	now := time.Now()
+	v, err := NewValidator(Config{
+		URL:            s.url("ldaps"),
+		TLSConfig:      clientTLS,
+		UserDNTemplate: "uid=%s,ou=people,dc=example,dc=com",
+		GroupFilter:    "(&(objectClass=groupOfNames)(member=%s))",
+		GroupBaseDN:    "ou=groups,dc=example,dc=com",
+		GroupConfig:    map[string][]string{"vpn": {`push "route 10.10.0.0 255.255.0.0"`}},
+	})
+	require.NoError(t, err)
+	defer v.Close()
+	v.now = func() time.Time { return now }
+
+	// client 1 is denied by a later check, its config is never asked for
+	valid, err := v.Validate(1, "alice", "alice-secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	now = now.Add(2 * clientConfigTTL)
+	assert.Empty(t, v.ClientConfig(1, server.ClientInfo{Username: "alice"}))
+
+	valid, err = v.Validate(1, "alice", "alice-secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	now = now.Add(2 * clientConfigTTL)
+	valid, err = v.Validate(2, "alice", "alice-secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Len(t, v.configs, 1, "expired config is dropped")
+	assert.NotEmpty(t, v.ClientConfig(2, server.ClientInfo{Username: "alice"}))
+}
+
+func TestSearchThenBindOverStartTLSWithRequiredGroup(t *testing.T) {
+	s, clientTLS := newStandIn(t, false)
+	defer s.close()
+
+	v, err := NewValidator(Config{
+		URL:            s.url("ldap"),
+		StartTLS:       true,
+		TLSConfig:      clientTLS,
+		BindDN:         "cn=service,dc=example,dc=com",
+		BindPassword:   "service-secret",
+		BaseDN:         "dc=example,dc=com",
+		UserFilter:     "(&(objectClass=person)(uid=%s))",
+		GroupFilter:    "(member=%s)",
+		RequiredGroups: []string{"VPN"},
+	})
+	require.NoError(t, err)
+	defer v.Close()
+
+	result, ok, err := v.Authenticate("alice", "alice-secret")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "uid=alice,ou=people,dc=example,dc=com", result.DN)
+	assert.ElementsMatch(t, []string{"vpn", "admins"}, result.Groups)
+
+	_, ok, err = v.Authenticate("bob", "bob-secret")
+	assert.NoError(t, err)
+	assert.False(t, ok, "bob is not in the required group")
+
+	_, ok, err = v.Authenticate("*", "alice-secret")
+	assert.NoError(t, err)
+	assert.False(t, ok, "filter injection")
+}
+
+func TestConnectionsArePooledAndResultsCached(t *testing.T) {
+	s, clientTLS := newStandIn(t, true)
+	defer s.close()
+
+	now := time.Now()
+	v, err := NewValidator(Config{
+		URL:            s.url("ldaps"),
+		TLSConfig:      clientTLS,
+		UserDNTemplate: "uid=%s,ou=people,dc=example,dc=com",
+		CacheTTL:       time.Minute,
+	})
+	require.NoError(t, err)
+	defer v.Close()
+	v.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		valid, err := v.Validate(i, "alice", "alice-secret")
+		assert.NoError(t, err)
+		assert.True(t, valid)
+		valid, err = v.Validate(i, "bob", "wrong")
+		assert.NoError(t, err)
+		assert.False(t, valid)
+	}
+	alice, bob := "uid=alice,ou=people,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"
+	binds, connections := s.stats()
+	assert.Equal(t, 1, connections)
+	assert.Equal(t, []string{alice, "", bob, bob, bob}, binds, "alice once, failed bob attempts are not cached")
+
+	now = now.Add(2 * time.Minute)
+	valid, err := v.Validate(9, "alice", "alice-secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	binds, _ = s.stats()
+	assert.Equal(t, []string{alice, ""}, binds[5:], "expired cache entry, pooled connection is bound anonymously again")
+
+	now = now.Add(2 * time.Minute)
+	valid, err = v.Validate(10, "bob", "bob-secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Len(t, v.cache, 1, "expired entries of other users are swept")
+}
+
+func TestEscapeDN(t *testing.T) {
+	assert.Equal(t, `alice`, escapeDN("alice"))
+	assert.Equal(t, `\#a\,b\+c\=d\\e\ `, escapeDN(`#a,b+c=d\e `))
+	assert.Equal(t, `\ x`, escapeDN(" x"))
+}
@@ -29,26 +29,70 @@ import (
 type Middleware struct {
 	*auth.Middleware
 
-	validator Validator
+	validator InfoValidator
+	checks    []Check
+	throttle  *Throttle
+	tokens    *TokenIssuer
+	configs   []ConfigProvider
 }
 
 // Validator callback checks given auth primitives.
 type Validator func(clientID int, username, password string) (bool, error)
 
-// NewMiddleware creates server user_auth challenge authentication Middleware
-func NewMiddleware(validator Validator) *Middleware {
+// InfoValidator callback checks given auth primitives with typed client info.
+type InfoValidator func(clientID int, info server.ClientInfo, password string) (bool, error)
+
+// Check callback is consulted before credentials are validated.
+// Returned error denies the client, error text is sent to the client as a reason.
+type Check func(event server.ClientEvent) error
+
+// ConfigProvider callback returns client specific config lines, i.e. push options or iroute, of the accepted client.
+type ConfigProvider func(clientID int, info server.ClientInfo) []string
+
+// NewMiddleware creates server user_auth challenge authentication Middleware.
+// Validator may be nil when clients are authorized by checks only.
+func NewMiddleware(validator Validator, checks ...Check) *Middleware {
+	var infoValidator InfoValidator
+	if validator != nil {
+		infoValidator = func(clientID int, info server.ClientInfo, password string) (bool, error) {
+			return validator(clientID, info.Username, password)
+		}
+	}
+	return NewInfoMiddleware(infoValidator, checks...)
+}
+
+// NewInfoMiddleware creates server user_auth challenge authentication Middleware,
+// which passes typed client info to the validator.
+func NewInfoMiddleware(validator InfoValidator, checks ...Check) *Middleware {
 	m := new(Middleware)
 	m.Middleware = auth.NewMiddleware(m.handleClientEvent)
 	m.validator = validator
+	m.checks = checks
 	return m
 }
 
+// UseThrottle enables brute-force protection of the credentials validator.
+func (m *Middleware) UseThrottle(throttle *Throttle) {
+	m.throttle = throttle
+}
+
+// UseAuthTokens enables auth-token issuance. Each authenticated client gets a token pushed,
+// which is then validated locally when it is sent instead of a password on renegotiation.
+// Tokens are not accepted on CONNECT, new connections are always validated by the validator.
+func (m *Middleware) UseAuthTokens(issuer *TokenIssuer) {
+	m.tokens = issuer
+}
+
+// UseClientConfig enables sending client specific config lines to accepted clients.
+// Lines of several providers are concatenated.
+func (m *Middleware) UseClientConfig(provider ConfigProvider) {
+	m.configs = append(m.configs, provider)
+}
+
 func (m *Middleware) handleClientEvent(event server.ClientEvent) {
 	switch event.EventType {
 	case server.Connect, server.Reauth:
-		username := event.Env["username"]
-		password := event.Env["password"]
-		err := m.authenticateClient(event.ClientID, event.ClientKey, username, password)
+		err := m.authenticateClient(event)
 		if err != nil {
 			log.Error("Unable to authenticate client:", err)
 		}
@@ -59,21 +103,80 @@ func (m *Middleware) handleClientEvent(event server.ClientEvent) {
 	}
 }
 
-func (m *Middleware) authenticateClient(clientID, clientKey int, username, password string) error {
+func (m *Middleware) authenticateClient(event server.ClientEvent) error {
+	clientID, clientKey := event.ClientID, event.ClientKey
+	username := event.Env["username"]
+	password := event.Env["password"]
+
 	log.Info("Authenticating user:", username, "clientID:", clientID, "clientKey:", clientKey)
+	for _, check := range m.checks {
+		if err := check(event); err != nil {
+			log.Info("Client with ID:", clientID, "rejected by check:", err)
+			return m.ClientDenyWithMessage(clientID, clientKey, err.Error())
+		}
+	}
+
+	sourceIP := event.Env["untrusted_ip"]
+	if m.throttle != nil {
+		if err := m.throttle.Check(sourceIP, username); err != nil {
+			log.Warn("Throttled user:", username, "from:", sourceIP, err)
+			return m.ClientDenyWithMessage(clientID, clientKey, err.Error())
+		}
+	}
+
+	if m.validator == nil {
+		return m.accept(event, false)
+	}
+
 	if username == "" || password == "" {
 		return m.ClientDenyWithMessage(clientID, clientKey, "missing username or password")
 	}
 
-	authenticated, err := m.validator(clientID, username, password)
+	// tokens only spare renegotiations, a new connection always goes through the validator
+	if m.tokens != nil && event.EventType == server.Reauth && IsToken(password) {
+		if err := m.tokens.Validate(username, password); err != nil {
+			log.Warn("Auth token rejected for user:", username, err)
+			if m.throttle != nil {
+				m.throttle.Failure(sourceIP, username)
+			}
+			return m.ClientDenyWithMessage(clientID, clientKey, err.Error())
+		}
+		return m.accept(event, false)
+	}
+
+	authenticated, err := m.validator(clientID, event.Info(), password)
 	if err != nil {
 		log.Error("Authentication error:", err)
 		return m.ClientDenyWithMessage(clientID, clientKey, "internal error")
 	}
 
 	if !authenticated {
+		if m.throttle != nil {
+			m.throttle.Failure(sourceIP, username)
+		}
 		return m.ClientDenyWithMessage(clientID, clientKey, "wrong username or password")
 	}
 
-	return m.ClientAccept(clientID, clientKey)
+	if m.throttle != nil {
+		m.throttle.Success(sourceIP, username)
+	}
+
+	return m.accept(event, m.tokens != nil)
+}
+
+func (m *Middleware) accept(event server.ClientEvent, issueToken bool) error {
+	info := event.Info()
+	var config []string
+	for _, provider := range m.configs {
+		config = append(config, provider(event.ClientID, info)...)
+	}
+	if issueToken {
+		token, err := m.tokens.Issue(info.Username)
+		if err != nil {
+			log.Error("Unable to issue auth token:", err)
+		} else {
+			config = append(config, `push "auth-token `+token+`"`)
+		}
+	}
+	return m.ClientAcceptWithConfig(event.ClientID, event.ClientKey, config)
 }
@@ -20,6 +20,7 @@
 package credentials
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -73,7 +74,7 @@ func Test_ConsumeLineAuthFalseChecker(t *testing.T) {
 		ClientID:  3,
 		ClientKey: 4,
 	})
-	assert.Equal(t, "client-deny 3 4 missing username or password", mockConnection.LastLine)
+	assert.Equal(t, `client-deny 3 4 "missing username or password" "missing username or password"`, mockConnection.LastLine)
 
 	middleware.handleClientEvent(server.ClientEvent{
 		EventType: server.Connect,
@@ -84,5 +85,90 @@ func Test_ConsumeLineAuthFalseChecker(t *testing.T) {
 			"password": "wrong",
 		},
 	})
-	assert.Equal(t, "client-deny 3 4 wrong username or password", mockConnection.LastLine)
+	assert.Equal(t, `client-deny 3 4 "wrong username or password" "wrong username or password"`, mockConnection.LastLine)
+}
+
+func Test_ChecksDenyBeforeValidation(t *testing.T) {
+	fas := fakeValidator{}
+
+	mockConnection := &management.MockConnection{}
+	middleware := NewMiddleware(fas.authenticateClient, func(event server.ClientEvent) error {
+		if event.Env["username"] == "blocked" {
+			return errors.New("user is blocked")
+		}
+		return nil
+	})
+	middleware.Start(mockConnection)
+
+	middleware.handleClientEvent(server.ClientEvent{
+		EventType: server.Connect,
+		ClientID:  3,
+		ClientKey: 4,
+		Env: map[string]string{
+			"username": "blocked",
+			"password": "12341234",
+		},
+	})
+	assert.Equal(t, `client-deny 3 4 "user is blocked" "user is blocked"`, mockConnection.LastLine)
+	assert.False(t, fas.called)
+
+	middleware.handleClientEvent(server.ClientEvent{
+		EventType: server.Connect,
+		ClientID:  3,
+		ClientKey: 4,
+		Env: map[string]string{
+			"username": "username1",
+			"password": "12341234",
+		},
+	})
+	assert.Equal(t, "client-auth-nt 3 4", mockConnection.LastLine)
+	assert.True(t, fas.called)
+}
+
+func Test_InfoValidatorReceivesClientInfo(t *testing.T) {
+	var received server.ClientInfo
+	mockConnection := &management.MockConnection{}
+	middleware := NewInfoMiddleware(func(clientID int, info server.ClientInfo, password string) (bool, error) {
+		received = info
+		return password == "12341234", nil
+	})
+	middleware.Start(mockConnection)
+
+	middleware.handleClientEvent(server.ClientEvent{
+		EventType: server.Connect,
+		ClientID:  3,
+		ClientKey: 4,
+		Env: map[string]string{
+			"username":     "username1",
+			"password":     "12341234",
+			"untrusted_ip": "1.2.3.4",
+			"IV_PLAT":      "android",
+		},
+	})
+	assert.Equal(t, "client-auth-nt 3 4", mockConnection.LastLine)
+	assert.Equal(t, "username1", received.Username)
+	assert.Equal(t, "1.2.3.4", received.RemoteIP.String())
+	assert.Equal(t, server.PlatformAndroid, received.Platform)
+}
+
+func Test_ClientConfigIsSentToAcceptedClient(t *testing.T) {
+	fas := fakeValidator{}
+
+	mockConnection := &management.MockConnection{}
+	middleware := NewMiddleware(fas.authenticateClient)
+	middleware.UseClientConfig(func(clientID int, info server.ClientInfo) []string {
+		return []string{`push "route 10.1.0.0 255.255.0.0"`}
+	})
+	middleware.Start(mockConnection)
+
+	middleware.handleClientEvent(server.ClientEvent{
+		EventType: server.Connect,
+		ClientID:  3,
+		ClientKey: 4,
+		Env: map[string]string{
+			"username": "username1",
+			"password": "12341234",
+		},
+	})
+	assert.Equal(t, "client-auth 3 4\npush \"route 10.1.0.0 255.255.0.0\"\nEND", mockConnection.LastLine)
 }
@@ -0,0 +1,298 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLockedOut is returned for clients which failed to authenticate too many times.
+	ErrLockedOut = errors.New("too many failed attempts, try again later")
+	// ErrDenied is returned for clients matching throttle deny list.
+	ErrDenied = errors.New("access denied")
+)
+
+// DefaultMaxThrottleEntries is a default cap of tracked addresses and usernames.
+const DefaultMaxThrottleEntries = 10000
+
+// LockoutKind tells what kind of identity was locked out.
+type LockoutKind string
+
+const (
+	// LockoutIP is a lockout of the client source address.
+	LockoutIP = LockoutKind("ip")
+	// LockoutUsername is a lockout of the username.
+	LockoutUsername = LockoutKind("username")
+)
+
+// LockoutEvent is emitted when a source address or a username gets locked out.
+type LockoutEvent struct {
+	Kind     LockoutKind
+	Value    string
+	Failures int
+	Until    time.Time
+}
+
+// LockoutCallback is called on every lockout.
+type LockoutCallback func(LockoutEvent)
+
+// ThrottleConfig configures brute-force protection.
+type ThrottleConfig struct {
+	// MaxFailures is a number of consecutive failures allowed before lockout.
+	MaxFailures int
+	// BaseLockout is a duration of the first lockout, each further failure doubles it.
+	BaseLockout time.Duration
+	// MaxLockout caps lockout duration, failures older than that are forgotten. Zero means no cap.
+	MaxLockout time.Duration
+	// MaxEntries caps number of tracked addresses and usernames each, least recently failed are forgotten first.
+	// Zero means DefaultMaxThrottleEntries.
+	MaxEntries int
+	// AllowList holds addresses, subnets or usernames which are never throttled.
+	AllowList []string
+	// DenyList holds addresses, subnets or usernames which are always denied.
+	DenyList []string
+	// OnLockout is optional and may be nil.
+	OnLockout LockoutCallback
+}
+
+type failures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type matcher struct {
+	subnets   []*net.IPNet
+	usernames map[string]bool
+}
+
+func newMatcher(entries []string) (matcher, error) {
+	m := matcher{usernames: make(map[string]bool)}
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") && net.ParseIP(entry) != nil {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		if strings.Contains(entry, "/") {
+			_, subnet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return m, err
+			}
+			m.subnets = append(m.subnets, subnet)
+			continue
+		}
+		m.usernames[entry] = true
+	}
+	return m, nil
+}
+
+func (m matcher) match(ip, username string) bool {
+	if m.usernames[username] {
+		return true
+	}
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+	for _, subnet := range m.subnets {
+		if subnet.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Throttle counts failed authentications per source address and per username
+// and locks them out with exponentially growing duration.
+type Throttle struct {
+	config ThrottleConfig
+	allow  matcher
+	deny   matcher
+	now    func() time.Time
+
+	mu        sync.Mutex
+	state     map[LockoutKind]map[string]*failures
+	lastSweep time.Time
+}
+
+// NewThrottle creates new instance of Throttle.
+func NewThrottle(config ThrottleConfig) (*Throttle, error) {
+	allow, err := newMatcher(config.AllowList)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := newMatcher(config.DenyList)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultMaxThrottleEntries
+	}
+
+	return &Throttle{
+		config: config,
+		allow:  allow,
+		deny:   deny,
+		now:    time.Now,
+		state: map[LockoutKind]map[string]*failures{
+			LockoutIP:       make(map[string]*failures),
+			LockoutUsername: make(map[string]*failures),
+		},
+	}, nil
+}
+
+// Check tells whether authentication attempt from given address and username may proceed.
+func (t *Throttle) Check(ip, username string) error {
+	if t.allow.match(ip, username) {
+		return nil
+	}
+	if t.deny.match(ip, username) {
+		return ErrDenied
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for kind, value := range map[LockoutKind]string{LockoutIP: ip, LockoutUsername: username} {
+		if f, ok := t.state[kind][value]; ok && now.Before(f.lockedUntil) {
+			return ErrLockedOut
+		}
+	}
+	return nil
+}
+
+// Failure records failed authentication attempt.
+func (t *Throttle) Failure(ip, username string) {
+	if t.allow.match(ip, username) {
+		return
+	}
+
+	t.mu.Lock()
+	events := []*LockoutEvent{
+		t.fail(LockoutIP, ip),
+		t.fail(LockoutUsername, username),
+	}
+	t.mu.Unlock()
+
+	for _, event := range events {
+		if event != nil && t.config.OnLockout != nil {
+			t.config.OnLockout(*event)
+		}
+	}
+}
+
+// Success forgets previous failures of given address and username.
+func (t *Throttle) Success(ip, username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.state[LockoutIP], ip)
+	delete(t.state[LockoutUsername], username)
+}
+
+func (t *Throttle) fail(kind LockoutKind, value string) *LockoutEvent {
+	if value == "" {
+		return nil
+	}
+
+	now := t.now()
+	t.sweep(now)
+	f, ok := t.state[kind][value]
+	if !ok || t.expired(f, now) {
+		if !ok && len(t.state[kind]) >= t.config.MaxEntries {
+			t.evictOldest(kind, now)
+		}
+		f = &failures{}
+		t.state[kind][value] = f
+	}
+	f.count++
+	f.lastFailure = now
+
+	excess := f.count - t.config.MaxFailures
+	if excess <= 0 {
+		return nil
+	}
+
+	lockout := t.config.BaseLockout
+	for i := 1; i < excess && (t.config.MaxLockout == 0 || lockout < t.config.MaxLockout); i++ {
+		lockout *= 2
+	}
+	if t.config.MaxLockout > 0 && lockout > t.config.MaxLockout {
+		lockout = t.config.MaxLockout
+	}
+	f.lockedUntil = now.Add(lockout)
+
+	return &LockoutEvent{
+		Kind:     kind,
+		Value:    value,
+		Failures: f.count,
+		Until:    f.lockedUntil,
+	}
+}
+
+// expired tells whether failures are old enough to be forgotten.
+func (t *Throttle) expired(f *failures, now time.Time) bool {
+	return t.config.MaxLockout > 0 && now.Sub(f.lastFailure) > t.config.MaxLockout && !now.Before(f.lockedUntil)
+}
+
+// sweep forgets expired failures at most once per MaxLockout.
+func (t *Throttle) sweep(now time.Time) {
+	if t.config.MaxLockout <= 0 || now.Sub(t.lastSweep) < t.config.MaxLockout {
+		return
+	}
+	t.lastSweep = now
+
+	for _, entries := range t.state {
+		for value, f := range entries {
+			if t.expired(f, now) {
+				delete(entries, value)
+			}
+		}
+	}
+}
+
+// evictOldest forgets the least recently failed entry of the kind, entries which are not locked out go first.
+func (t *Throttle) evictOldest(kind LockoutKind, now time.Time) {
+	var oldest string
+	var oldestFailure *failures
+	for value, f := range t.state[kind] {
+		if oldestFailure == nil || older(f, oldestFailure, now) {
+			oldest, oldestFailure = value, f
+		}
+	}
+	delete(t.state[kind], oldest)
+}
+
+func older(f, other *failures, now time.Time) bool {
+	locked, otherLocked := now.Before(f.lockedUntil), now.Before(other.lockedUntil)
+	if locked != otherLocked {
+		return otherLocked
+	}
+	return f.lastFailure.Before(other.lastFailure)
+}
@@ -0,0 +1,164 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+func newTestThrottle(t *testing.T, config ThrottleConfig) (*Throttle, *time.Time) {
+	throttle, err := NewThrottle(config)
+	assert.NoError(t, err)
+
+	now := time.Unix(1000, 0)
+	throttle.now = func() time.Time { return now }
+	return throttle, &now
+}
+
+func Test_ThrottleLockoutGrowsExponentially(t *testing.T) {
+	var events []LockoutEvent
+	throttle, now := newTestThrottle(t, ThrottleConfig{
+		MaxFailures: 2,
+		BaseLockout: time.Minute,
+		MaxLockout:  3 * time.Minute,
+		OnLockout: func(event LockoutEvent) {
+			events = append(events, event)
+		},
+	})
+
+	throttle.Failure("1.1.1.1", "alice")
+	throttle.Failure("1.1.1.1", "alice")
+	assert.NoError(t, throttle.Check("1.1.1.1", "alice"))
+
+	throttle.Failure("1.1.1.1", "alice")
+	assert.Equal(t, ErrLockedOut, throttle.Check("1.1.1.1", "bob"))
+	assert.Equal(t, ErrLockedOut, throttle.Check("2.2.2.2", "alice"))
+	assert.NoError(t, throttle.Check("2.2.2.2", "bob"))
+	assert.Len(t, events, 2)
+	assert.Equal(t, LockoutEvent{Kind: LockoutIP, Value: "1.1.1.1", Failures: 3, Until: now.Add(time.Minute)}, events[0])
+	assert.Equal(t, LockoutUsername, events[1].Kind)
+
+	*now = now.Add(time.Minute)
+	assert.NoError(t, throttle.Check("1.1.1.1", "alice"))
+
+	throttle.Failure("1.1.1.1", "alice")
+	assert.Equal(t, now.Add(2*time.Minute), events[2].Until)
+	throttle.Failure("1.1.1.1", "alice")
+	assert.Equal(t, now.Add(3*time.Minute), events[4].Until)
+
+	throttle.Success("1.1.1.1", "alice")
+	assert.NoError(t, throttle.Check("1.1.1.1", "alice"))
+}
+
+func Test_ThrottleForgetsExpiredFailures(t *testing.T) {
+	throttle, now := newTestThrottle(t, ThrottleConfig{
+		MaxFailures: 1,
+		BaseLockout: time.Minute,
+		MaxLockout:  time.Hour,
+	})
+
+	throttle.Failure("1.1.1.1", "alice")
+	throttle.Failure("1.1.1.1", "alice")
+	throttle.Failure("2.2.2.2", "bob")
+	assert.Len(t, throttle.state[LockoutIP], 2)
+
+	*now = now.Add(time.Hour + time.Second)
+	throttle.Failure("3.3.3.3", "carol")
+	assert.Len(t, throttle.state[LockoutIP], 1)
+	assert.Len(t, throttle.state[LockoutUsername], 1)
+	assert.Contains(t, throttle.state[LockoutUsername], "carol")
+}
+
+func Test_ThrottleCapsTrackedEntries(t *testing.T) {
+	throttle, now := newTestThrottle(t, ThrottleConfig{
+		MaxFailures: 1,
+		BaseLockout: time.Minute,
+		MaxEntries:  2,
+	})
+
+	throttle.Failure("1.1.1.1", "alice")
+	throttle.Failure("1.1.1.1", "alice")
+	for _, username := range []string{"bob", "carol", "dave"} {
+		*now = now.Add(time.Second)
+		throttle.Failure("1.1.1.1", username)
+	}
+
+	assert.Len(t, throttle.state[LockoutUsername], 2)
+	assert.Contains(t, throttle.state[LockoutUsername], "alice", "locked out entries are kept")
+	assert.Contains(t, throttle.state[LockoutUsername], "dave")
+	assert.Equal(t, ErrLockedOut, throttle.Check("2.2.2.2", "alice"))
+}
+
+func Test_ThrottleAllowAndDenyLists(t *testing.T) {
+	throttle, _ := newTestThrottle(t, ThrottleConfig{
+		MaxFailures: 0,
+		BaseLockout: time.Minute,
+		AllowList:   []string{"10.0.0.0/8", "admin"},
+		DenyList:    []string{"6.6.6.6", "mallory"},
+	})
+
+	throttle.Failure("10.1.1.1", "alice")
+	assert.NoError(t, throttle.Check("10.1.1.1", "alice"))
+	throttle.Failure("1.1.1.1", "admin")
+	assert.NoError(t, throttle.Check("1.1.1.1", "admin"))
+
+	assert.Equal(t, ErrDenied, throttle.Check("6.6.6.6", "bob"))
+	assert.Equal(t, ErrDenied, throttle.Check("2.2.2.2", "mallory"))
+
+	_, err := NewThrottle(ThrottleConfig{DenyList: []string{"1.2.3.4/99"}})
+	assert.Error(t, err)
+}
+
+func Test_MiddlewareThrottlesFailedAttempts(t *testing.T) {
+	fas := fakeValidator{}
+	mockConnection := &management.MockConnection{}
+	middleware := NewMiddleware(fas.authenticateClient)
+	throttle, _ := newTestThrottle(t, ThrottleConfig{MaxFailures: 1, BaseLockout: time.Minute})
+	middleware.UseThrottle(throttle)
+	middleware.Start(mockConnection)
+
+	connect := func(password string) {
+		middleware.handleClientEvent(server.ClientEvent{
+			EventType: server.Connect,
+			ClientID:  3,
+			ClientKey: 4,
+			Env: map[string]string{
+				"username":     "username1",
+				"password":     password,
+				"untrusted_ip": "1.1.1.1",
+			},
+		})
+	}
+
+	connect("wrong")
+	assert.Equal(t, `client-deny 3 4 "wrong username or password" "wrong username or password"`, mockConnection.LastLine)
+	connect("wrong")
+	assert.Equal(t, `client-deny 3 4 "wrong username or password" "wrong username or password"`, mockConnection.LastLine)
+
+	fas.called = false
+	connect("12341234")
+	assert.Equal(t, `client-deny 3 4 "too many failed attempts, try again later" "too many failed attempts, try again later"`, mockConnection.LastLine)
+	assert.False(t, fas.called)
+}
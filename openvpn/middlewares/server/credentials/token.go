@@ -0,0 +1,184 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tokenPrefix = "AT1."
+
+var (
+	// ErrInvalidToken is returned for malformed or forged auth-tokens.
+	ErrInvalidToken = errors.New("invalid auth token")
+	// ErrTokenExpired is returned for auth-tokens past their expiry.
+	ErrTokenExpired = errors.New("auth token expired")
+	// ErrTokenRevoked is returned for revoked auth-tokens.
+	ErrTokenRevoked = errors.New("auth token revoked")
+)
+
+var tokenEncoding = base64.RawURLEncoding
+
+// token payload layout: issued at (8 bytes), expires at (8 bytes), nonce (8 bytes), username
+const tokenHeaderSize = 24
+
+// IsToken tells whether the password looks like an auth-token issued by TokenIssuer.
+func IsToken(password string) bool {
+	return strings.HasPrefix(password, tokenPrefix)
+}
+
+// TokenIssuer issues and validates HMAC signed auth-tokens, which are pushed to clients
+// so that renegotiations don't need the original password.
+type TokenIssuer struct {
+	secret   []byte
+	lifetime time.Duration
+	now      func() time.Time
+
+	mu            sync.Mutex
+	revokedTokens map[string]time.Time
+	revokedUsers  map[string]time.Time
+}
+
+// NewTokenIssuer creates new instance of TokenIssuer.
+func NewTokenIssuer(secret []byte, lifetime time.Duration) *TokenIssuer {
+	return &TokenIssuer{
+		secret:        secret,
+		lifetime:      lifetime,
+		now:           time.Now,
+		revokedTokens: make(map[string]time.Time),
+		revokedUsers:  make(map[string]time.Time),
+	}
+}
+
+// Issue creates new token of the user.
+func (i *TokenIssuer) Issue(username string) (string, error) {
+	now := i.now()
+
+	payload := make([]byte, tokenHeaderSize, tokenHeaderSize+len(username))
+	binary.BigEndian.PutUint64(payload[0:8], uint64(now.UnixNano()))
+	binary.BigEndian.PutUint64(payload[8:16], uint64(now.Add(i.lifetime).UnixNano()))
+	if _, err := rand.Read(payload[16:24]); err != nil {
+		return "", err
+	}
+	payload = append(payload, username...)
+
+	return tokenPrefix + tokenEncoding.EncodeToString(payload) + "." + tokenEncoding.EncodeToString(i.sign(payload)), nil
+}
+
+// Validate checks that the token was issued to the user, is not expired and not revoked.
+func (i *TokenIssuer) Validate(username, token string) error {
+	payload, err := i.verify(token)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(payload[tokenHeaderSize:], []byte(username)) != 1 {
+		return ErrInvalidToken
+	}
+
+	now := i.now()
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(payload[0:8])))
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(payload[8:16])))
+	if !now.Before(expires) {
+		return ErrTokenExpired
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, revoked := i.revokedTokens[token]; revoked {
+		return ErrTokenRevoked
+	}
+	if revokedAt, revoked := i.revokedUsers[username]; revoked && !issued.After(revokedAt) {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// Revoke invalidates single token.
+func (i *TokenIssuer) Revoke(token string) error {
+	payload, err := i.verify(token)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.cleanup()
+	i.revokedTokens[token] = time.Unix(0, int64(binary.BigEndian.Uint64(payload[8:16])))
+	return nil
+}
+
+// RevokeUser invalidates all tokens issued to the user so far.
+func (i *TokenIssuer) RevokeUser(username string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.cleanup()
+	i.revokedUsers[username] = i.now()
+}
+
+func (i *TokenIssuer) verify(token string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(token, tokenPrefix), ".")
+	if !IsToken(token) || len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := tokenEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) < tokenHeaderSize {
+		return nil, ErrInvalidToken
+	}
+	signature, err := tokenEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, i.sign(payload)) {
+		return nil, ErrInvalidToken
+	}
+	return payload, nil
+}
+
+func (i *TokenIssuer) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// cleanup forgets revocations which can't match any valid token anymore.
+func (i *TokenIssuer) cleanup() {
+	now := i.now()
+	for token, expires := range i.revokedTokens {
+		if now.After(expires) {
+			delete(i.revokedTokens, token)
+		}
+	}
+	for username, revokedAt := range i.revokedUsers {
+		if now.Sub(revokedAt) > i.lifetime {
+			delete(i.revokedUsers, username)
+		}
+	}
+}
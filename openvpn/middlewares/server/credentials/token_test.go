@@ -0,0 +1,126 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package credentials
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+func newTestIssuer() (*TokenIssuer, *time.Time) {
+	issuer := NewTokenIssuer([]byte("secret"), time.Hour)
+	now := time.Unix(1000, 0)
+	issuer.now = func() time.Time { return now }
+	return issuer, &now
+}
+
+func Test_TokenValidation(t *testing.T) {
+	issuer, now := newTestIssuer()
+
+	token, err := issuer.Issue("alice")
+	assert.NoError(t, err)
+	assert.True(t, IsToken(token))
+
+	assert.NoError(t, issuer.Validate("alice", token))
+	assert.Equal(t, ErrInvalidToken, issuer.Validate("bob", token))
+	assert.Equal(t, ErrInvalidToken, issuer.Validate("alice", token+"x"))
+	assert.Equal(t, ErrInvalidToken, issuer.Validate("alice", "AT1.garbage"))
+
+	other := NewTokenIssuer([]byte("other secret"), time.Hour)
+	assert.Equal(t, ErrInvalidToken, other.Validate("alice", token))
+
+	*now = now.Add(time.Hour)
+	assert.Equal(t, ErrTokenExpired, issuer.Validate("alice", token))
+}
+
+func Test_TokenRevocation(t *testing.T) {
+	issuer, now := newTestIssuer()
+
+	first, _ := issuer.Issue("alice")
+	*now = now.Add(time.Second)
+	second, _ := issuer.Issue("alice")
+
+	assert.NoError(t, issuer.Revoke(first))
+	assert.Equal(t, ErrTokenRevoked, issuer.Validate("alice", first))
+	assert.NoError(t, issuer.Validate("alice", second))
+
+	issuer.RevokeUser("alice")
+	assert.Equal(t, ErrTokenRevoked, issuer.Validate("alice", second))
+
+	*now = now.Add(time.Second)
+	third, _ := issuer.Issue("alice")
+	assert.NoError(t, issuer.Validate("alice", third))
+}
+
+func Test_MiddlewareIssuesAndAcceptsTokens(t *testing.T) {
+	fas := fakeValidator{}
+	mockConnection := &management.MockConnection{}
+	middleware := NewMiddleware(fas.authenticateClient)
+	issuer, _ := newTestIssuer()
+	middleware.UseAuthTokens(issuer)
+	middleware.Start(mockConnection)
+
+	middleware.handleClientEvent(server.ClientEvent{
+		EventType: server.Connect,
+		ClientID:  3,
+		ClientKey: 4,
+		Env:       map[string]string{"username": "username1", "password": "12341234"},
+	})
+	lines := strings.Split(mockConnection.LastLine, "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "client-auth 3 4", lines[0])
+	assert.Equal(t, "END", lines[2])
+	assert.True(t, strings.HasPrefix(lines[1], `push "auth-token AT1.`))
+	token := strings.TrimSuffix(strings.TrimPrefix(lines[1], `push "auth-token `), `"`)
+
+	fas.called = false
+	middleware.handleClientEvent(server.ClientEvent{
+		EventType: server.Reauth,
+		ClientID:  3,
+		ClientKey: 5,
+		Env:       map[string]string{"username": "username1", "password": token},
+	})
+	assert.Equal(t, "client-auth-nt 3 5", mockConnection.LastLine)
+	assert.False(t, fas.called)
+
+	// token does not replace the password of a new connection
+	middleware.handleClientEvent(server.ClientEvent{
+		EventType: server.Connect,
+		ClientID:  7,
+		ClientKey: 8,
+		Env:       map[string]string{"username": "username1", "password": token},
+	})
+	assert.Equal(t, `client-deny 7 8 "wrong username or password" "wrong username or password"`, mockConnection.LastLine)
+	assert.True(t, fas.called)
+
+	issuer.RevokeUser("username1")
+	middleware.handleClientEvent(server.ClientEvent{
+		EventType: server.Reauth,
+		ClientID:  3,
+		ClientKey: 6,
+		Env:       map[string]string{"username": "username1", "password": token},
+	})
+	assert.Equal(t, `client-deny 3 6 "auth token revoked" "auth token revoked"`, mockConnection.LastLine)
+}
@@ -0,0 +1,135 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecretSize is the size of generated secrets in bytes, as recommended by RFC 4226.
+const SecretSize = 20
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Config describes TOTP parameters. HMAC-SHA1 is used, as it is the one supported by authenticator apps.
+type Config struct {
+	// Issuer is the service name shown by authenticator apps.
+	Issuer string
+	// Digits is the code length between 6 and 8 as allowed by RFC 4226, 6 by default. Other lengths are clamped.
+	Digits int
+	// Period is the time step in whole seconds, 30 seconds by default and when shorter than a second.
+	Period time.Duration
+	// Skew is the number of time steps accepted before and after the current one to tolerate clock drift.
+	Skew int
+}
+
+func (c Config) withDefaults() Config {
+	if c.Digits < 6 {
+		c.Digits = 6
+	}
+	if c.Digits > 8 {
+		// the modulo of longer codes would overflow uint32 in hotp
+		c.Digits = 8
+	}
+	// authenticator apps count periods in seconds, a shorter one would divide by zero
+	c.Period = c.Period.Truncate(time.Second)
+	if c.Period <= 0 {
+		c.Period = 30 * time.Second
+	}
+	if c.Skew < 0 {
+		c.Skew = 0
+	}
+	return c
+}
+
+// GenerateSecret generates new random secret for user enrolment.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+// EncodeSecret encodes secret in base32 form used by authenticator apps.
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// DecodeSecret decodes base32 secret, spaces and lower case letters are accepted.
+func DecodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// ProvisioningURI builds otpauth:// URI for enrolment, usually shown as QR code.
+func ProvisioningURI(config Config, username string, secret []byte) string {
+	config = config.withDefaults()
+
+	label := url.PathEscape(username)
+	if config.Issuer != "" {
+		label = url.PathEscape(config.Issuer) + ":" + label
+	}
+	params := url.Values{}
+	params.Set("secret", EncodeSecret(secret))
+	if config.Issuer != "" {
+		params.Set("issuer", config.Issuer)
+	}
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(config.Digits))
+	params.Set("period", strconv.Itoa(int(config.Period/time.Second)))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// GenerateCode calculates the code valid at given time.
+func GenerateCode(config Config, secret []byte, at time.Time) string {
+	config = config.withDefaults()
+	return hotp(secret, counter(config, at), config.Digits)
+}
+
+func counter(config Config, at time.Time) int64 {
+	return at.Unix() / int64(config.Period/time.Second)
+}
+
+// hotp calculates HOTP value as defined by RFC 4226.
+func hotp(secret []byte, counter int64, digits int) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
@@ -0,0 +1,76 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package totp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B test vectors for SHA1.
+func TestGenerateCodeMatchesRFCVectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	config := Config{Digits: 8}
+	var tests = []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.code, GenerateCode(config, secret, time.Unix(test.unix, 0)), test.unix)
+	}
+	assert.Equal(t, "287082", GenerateCode(Config{}, secret, time.Unix(59, 0)))
+	assert.Equal(t, "287082", GenerateCode(Config{Period: 500 * time.Millisecond}, secret, time.Unix(59, 0)))
+	assert.Equal(t, "287082", GenerateCode(Config{Period: 30500 * time.Millisecond}, secret, time.Unix(59, 0)))
+	assert.Equal(t, "94287082", GenerateCode(Config{Digits: 10}, secret, time.Unix(59, 0)))
+	assert.Equal(t, "287082", GenerateCode(Config{Digits: 4}, secret, time.Unix(59, 0)))
+}
+
+func TestSecretEncodingRoundTrip(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, SecretSize)
+
+	decoded, err := DecodeSecret(EncodeSecret(secret))
+	assert.NoError(t, err)
+	assert.Equal(t, secret, decoded)
+
+	decoded, err = DecodeSecret("gezd gnbv gy3t qojq")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1234567890"), decoded)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI(Config{Issuer: "Example VPN"}, "alice@example.com", []byte("1234567890"))
+	assert.Equal(
+		t,
+		"otpauth://totp/Example%20VPN:alice@example.com?algorithm=SHA1&digits=6&issuer=Example+VPN&period=30&secret=GEZDGNBVGY3TQOJQ",
+		uri,
+	)
+}
@@ -0,0 +1,64 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package totp
+
+import "sync"
+
+// SecretStore provides TOTP secrets of users.
+type SecretStore interface {
+	// Secret returns secret of the user, found is false for users not enrolled.
+	Secret(username string) (secret []byte, found bool, err error)
+}
+
+// MemoryStore keeps secrets in memory.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	secrets map[string][]byte
+}
+
+// NewMemoryStore creates empty in memory secret store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{secrets: make(map[string][]byte)}
+}
+
+// Secret returns secret of the user.
+func (s *MemoryStore) Secret(username string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	secret, found := s.secrets[username]
+	return secret, found, nil
+}
+
+// SetSecret enrolls the user with given secret.
+func (s *MemoryStore) SetSecret(username string, secret []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.secrets[username] = secret
+}
+
+// DeleteSecret removes enrolment of the user.
+func (s *MemoryStore) DeleteSecret(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.secrets, username)
+}
@@ -0,0 +1,156 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package totp
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/credentials"
+)
+
+const staticChallengePrefix = "SCRV1:"
+
+// Validator checks password with the wrapped validator and TOTP code as a second factor.
+//
+// The code is accepted either appended to the password, i.e. "secret123456", or as
+// static challenge response in SCRV1 format, which OpenVPN clients send when configured
+// with the static-challenge directive. Every code is accepted only once per user.
+type Validator struct {
+	password credentials.Validator
+	store    SecretStore
+	config   Config
+	optional bool
+	now      func() time.Time
+
+	mu       sync.Mutex
+	lastUsed map[string]int64
+}
+
+// NewValidator creates TOTP validator which checks passwords with the given validator
+// and codes with secrets of the store.
+func NewValidator(password credentials.Validator, store SecretStore, config Config) *Validator {
+	return &Validator{
+		password: password,
+		store:    store,
+		config:   config.withDefaults(),
+		now:      time.Now,
+		lastUsed: make(map[string]int64),
+	}
+}
+
+// AllowUnenrolled lets users without a secret authenticate by password only.
+func (v *Validator) AllowUnenrolled() {
+	v.optional = true
+}
+
+// Validate checks given credentials, it conforms to credentials.Validator callback.
+func (v *Validator) Validate(clientID int, username, password string) (bool, error) {
+	secret, enrolled, err := v.store.Secret(username)
+	if err != nil {
+		return false, err
+	}
+
+	staticChallenge := strings.HasPrefix(password, staticChallengePrefix)
+	if !enrolled {
+		if !v.optional {
+			return false, nil
+		}
+		if staticChallenge {
+			var ok bool
+			if password, _, ok = parseStaticChallenge(password); !ok {
+				return false, nil
+			}
+		}
+		return v.password(clientID, username, password)
+	}
+
+	var code string
+	var ok bool
+	if staticChallenge {
+		password, code, ok = parseStaticChallenge(password)
+	} else {
+		password, code, ok = splitAppendedCode(password, v.config.Digits)
+	}
+	if !ok {
+		return false, nil
+	}
+
+	valid, err := v.password(clientID, username, password)
+	if err != nil || !valid {
+		return false, err
+	}
+	return v.useCode(username, secret, code), nil
+}
+
+// useCode checks code within the drift window and remembers it, so that it can not be replayed.
+func (v *Validator) useCode(username string, secret []byte, code string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	current := counter(v.config, v.now())
+	last, used := v.lastUsed[username]
+	for step := -v.config.Skew; step <= v.config.Skew; step++ {
+		c := current + int64(step)
+		if used && c <= last {
+			continue
+		}
+		expected := []byte(hotp(secret, c, v.config.Digits))
+		if subtle.ConstantTimeCompare(expected, []byte(code)) == 1 {
+			v.lastUsed[username] = c
+			return true
+		}
+	}
+	return false
+}
+
+// parseStaticChallenge parses "SCRV1:base64(password):base64(response)".
+func parseStaticChallenge(value string) (password, response string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(value, staticChallengePrefix), ":")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	decodedPassword, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", false
+	}
+	decodedResponse, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+	return string(decodedPassword), strings.TrimSpace(string(decodedResponse)), true
+}
+
+// splitAppendedCode splits trailing code digits from the password.
+func splitAppendedCode(value string, digits int) (password, code string, ok bool) {
+	if len(value) <= digits {
+		return "", "", false
+	}
+	password, code = value[:len(value)-digits], value[len(value)-digits:]
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", "", false
+		}
+	}
+	return password, code, true
+}
@@ -0,0 +1,137 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package totp
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var secret = []byte("12345678901234567890")
+
+func passwordValidator(_ int, username, password string) (bool, error) {
+	return password == "secret", nil
+}
+
+func newTestValidator(at *time.Time) *Validator {
+	store := NewMemoryStore()
+	store.SetSecret("alice", secret)
+	v := NewValidator(passwordValidator, store, Config{Skew: 1})
+	v.now = func() time.Time { return *at }
+	return v
+}
+
+func staticChallenge(password, response string) string {
+	return "SCRV1:" + base64.StdEncoding.EncodeToString([]byte(password)) + ":" + base64.StdEncoding.EncodeToString([]byte(response))
+}
+
+func TestValidatorAcceptsAppendedAndStaticChallengeCodes(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	v := newTestValidator(&now)
+
+	valid, err := v.Validate(1, "alice", "secret"+GenerateCode(Config{}, secret, now))
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	now = now.Add(time.Minute)
+	valid, err = v.Validate(1, "alice", staticChallenge("secret", GenerateCode(Config{}, secret, now)))
+	assert.NoError(t, err)
+	assert.True(t, valid)
+}
+
+func TestValidatorRejectsWrongCredentials(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	v := newTestValidator(&now)
+	code := GenerateCode(Config{}, secret, now)
+
+	var tests = []struct {
+		name     string
+		password string
+	}{
+		{"wrong password", "wrong" + code},
+		{"wrong code", "secret000000"},
+		{"missing code", "secret"},
+		{"malformed static challenge", "SCRV1:???:???"},
+		{"code outside drift window", "secret" + GenerateCode(Config{}, secret, now.Add(-2*time.Minute))},
+	}
+
+	for _, test := range tests {
+		valid, err := v.Validate(1, "alice", test.password)
+		assert.NoError(t, err, test.name)
+		assert.False(t, valid, test.name)
+	}
+}
+
+func TestValidatorToleratesDriftAndRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	v := newTestValidator(&now)
+	previous := GenerateCode(Config{}, secret, now.Add(-30*time.Second))
+	current := GenerateCode(Config{}, secret, now)
+
+	valid, _ := v.Validate(1, "alice", "secret"+current)
+	assert.True(t, valid)
+
+	valid, _ = v.Validate(2, "alice", "secret"+current)
+	assert.False(t, valid, "code replayed")
+
+	valid, _ = v.Validate(2, "alice", "secret"+previous)
+	assert.False(t, valid, "older code used after newer one")
+
+	now = now.Add(30 * time.Second)
+	valid, _ = v.Validate(3, "alice", "secret"+GenerateCode(Config{}, secret, now.Add(-30*time.Second)))
+	assert.False(t, valid, "drifted code already used")
+	valid, _ = v.Validate(3, "alice", "secret"+GenerateCode(Config{}, secret, now.Add(30*time.Second)))
+	assert.True(t, valid, "code of next step within drift window")
+}
+
+func TestValidatorHandlesUnenrolledUsers(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	v := newTestValidator(&now)
+
+	valid, err := v.Validate(1, "bob", "secret")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	v.AllowUnenrolled()
+	valid, err = v.Validate(1, "bob", "secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	valid, err = v.Validate(1, "bob", staticChallenge("secret", ""))
+	assert.NoError(t, err)
+	assert.True(t, valid)
+}
+
+type failingStore struct{}
+
+func (failingStore) Secret(string) ([]byte, bool, error) {
+	return nil, false, errors.New("store unavailable")
+}
+
+func TestValidatorReportsStoreErrors(t *testing.T) {
+	v := NewValidator(passwordValidator, failingStore{}, Config{})
+	valid, err := v.Validate(1, "alice", "secret123456")
+	assert.Error(t, err)
+	assert.False(t, valid)
+}
@@ -0,0 +1,222 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package userfile
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type scheme int
+
+const (
+	schemeBcrypt scheme = iota
+	schemeSHA1
+	schemeAPR1
+	schemeArgon2
+)
+
+const (
+	apr1Magic = "$apr1$"
+	sha1Magic = "{SHA}"
+	itoa64    = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+func detectScheme(hash string) (scheme, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return schemeBcrypt, nil
+	case strings.HasPrefix(hash, sha1Magic):
+		return schemeSHA1, nil
+	case strings.HasPrefix(hash, apr1Magic):
+		return schemeAPR1, nil
+	case strings.HasPrefix(hash, "$argon2id$"), strings.HasPrefix(hash, "$argon2i$"):
+		return schemeArgon2, nil
+	}
+	return 0, errors.New("unsupported password hash format")
+}
+
+func verifyPassword(s scheme, hash, password string) (bool, error) {
+	switch s {
+	case schemeBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	case schemeSHA1:
+		sum := sha1.Sum([]byte(password))
+		return constantTimeEqual(hash, sha1Magic+base64.StdEncoding.EncodeToString(sum[:])), nil
+	case schemeAPR1:
+		parts := strings.SplitN(strings.TrimPrefix(hash, apr1Magic), "$", 2)
+		if len(parts) != 2 {
+			return false, errors.New("malformed apr1 hash")
+		}
+		return constantTimeEqual(hash, apr1(password, parts[0])), nil
+	case schemeArgon2:
+		return verifyArgon2(hash, password)
+	}
+	return false, errors.New("unsupported password hash format")
+}
+
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// apr1 implements Apache variant of MD5 based crypt.
+func apr1(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alternate := md5.Sum([]byte(password + salt + password))
+
+	ctx := md5.New()
+	ctx.Write([]byte(password + apr1Magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(alternate[:])
+		} else {
+			ctx.Write(alternate[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var encoded strings.Builder
+	to64 := func(v uint, n int) {
+		for ; n > 0; n-- {
+			encoded.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	to64(uint(final[0])<<16|uint(final[6])<<8|uint(final[12]), 4)
+	to64(uint(final[1])<<16|uint(final[7])<<8|uint(final[13]), 4)
+	to64(uint(final[2])<<16|uint(final[8])<<8|uint(final[14]), 4)
+	to64(uint(final[3])<<16|uint(final[9])<<8|uint(final[15]), 4)
+	to64(uint(final[4])<<16|uint(final[10])<<8|uint(final[5]), 4)
+	to64(uint(final[11]), 2)
+
+	return apr1Magic + salt + "$" + encoded.String()
+}
+
+// maxArgon2Memory limits argon2 memory cost in KiB, so that a users file can not exhaust memory of the process.
+const maxArgon2Memory = 1 << 20
+
+type argon2Params struct {
+	id       bool
+	memory   uint32
+	time     uint32
+	threads  uint8
+	salt     []byte
+	expected []byte
+}
+
+// parseArgon2 parses and checks PHC encoded argon2 hash, i.e.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func parseArgon2(hash string) (argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return argon2Params{}, errors.New("malformed argon2 hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return argon2Params{}, fmt.Errorf("malformed argon2 version: %v", err)
+	}
+	if version != argon2.Version {
+		return argon2Params{}, fmt.Errorf("unsupported argon2 version: %d", version)
+	}
+
+	h := argon2Params{id: parts[1] == "argon2id"}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return argon2Params{}, fmt.Errorf("malformed argon2 parameters: %v", err)
+	}
+	if h.time < 1 || h.threads < 1 {
+		return argon2Params{}, errors.New("argon2 time and parallelism must be at least 1")
+	}
+	if h.memory < 8*uint32(h.threads) || h.memory > maxArgon2Memory {
+		return argon2Params{}, fmt.Errorf("argon2 memory must be between %d and %d KiB", 8*uint32(h.threads), maxArgon2Memory)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Params{}, fmt.Errorf("malformed argon2 salt: %v", err)
+	}
+	if h.expected, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return argon2Params{}, fmt.Errorf("malformed argon2 hash: %v", err)
+	}
+	if len(h.expected) == 0 {
+		return argon2Params{}, errors.New("empty argon2 hash")
+	}
+	return h, nil
+}
+
+// verifyArgon2 checks password against PHC encoded argon2 hash.
+func verifyArgon2(hash, password string) (bool, error) {
+	h, err := parseArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+
+	var actual []byte
+	if h.id {
+		actual = argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.expected)))
+	} else {
+		actual = argon2.Key([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.expected)))
+	}
+	return subtle.ConstantTimeCompare(actual, h.expected) == 1, nil
+}
@@ -0,0 +1,189 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package userfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+)
+
+type entry struct {
+	scheme scheme
+	hash   string
+}
+
+type parser func(data []byte) (map[string]entry, error)
+
+// Validator checks credentials against users file.
+// The file is reloaded whenever its modification time or size changes.
+type Validator struct {
+	path  string
+	parse parser
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	users   map[string]entry
+	// dummy is verified for unknown usernames, so that they take as long to reject as wrong passwords
+	dummy *entry
+}
+
+// NewHtpasswdValidator creates validator of Apache htpasswd file.
+// Supported hashes are bcrypt, SHA1 and APR1-MD5.
+func NewHtpasswdValidator(path string) (*Validator, error) {
+	return newValidator(path, parseHtpasswd)
+}
+
+// NewJSONValidator creates validator of JSON file which maps usernames to bcrypt or argon2 hashes, i.e.
+// {"alice": "$2y$10$...", "bob": "$argon2id$v=19$m=65536,t=3,p=4$..."}
+func NewJSONValidator(path string) (*Validator, error) {
+	return newValidator(path, parseJSON)
+}
+
+func newValidator(path string, parse parser) (*Validator, error) {
+	v := &Validator{
+		path:  path,
+		parse: parse,
+	}
+	if err := v.reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Validate checks given credentials, it conforms to credentials.Validator callback.
+func (v *Validator) Validate(_ int, username, password string) (bool, error) {
+	v.mu.Lock()
+	if err := v.reload(); err != nil {
+		log.Error("Unable to reload users file, using previous version:", err)
+	}
+	user, ok := v.users[username]
+	dummy := v.dummy
+	v.mu.Unlock()
+
+	if !ok {
+		if dummy != nil {
+			verifyPassword(dummy.scheme, dummy.hash, password)
+		}
+		return false, nil
+	}
+	return verifyPassword(user.scheme, user.hash, password)
+}
+
+func (v *Validator) reload() error {
+	info, err := os.Stat(v.path)
+	if err != nil {
+		return err
+	}
+	if v.users != nil && info.ModTime().Equal(v.modTime) && info.Size() == v.size {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(v.path)
+	if err != nil {
+		return err
+	}
+	users, err := v.parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid users file %s: %v", v.path, err)
+	}
+
+	v.users = users
+	v.dummy = dummyEntry(users)
+	v.modTime = info.ModTime()
+	v.size = info.Size()
+	log.Info("Loaded", len(users), "users from:", v.path)
+	return nil
+}
+
+// dummyEntry picks the hash of the first username in order, preferring bcrypt and argon2 hashes,
+// so that its cost matches the cost of real password checks.
+func dummyEntry(users map[string]entry) *entry {
+	var dummy *entry
+	first := ""
+	for username, user := range users {
+		if dummy == nil || user.slow() && !dummy.slow() || user.slow() == dummy.slow() && username < first {
+			user := user
+			dummy, first = &user, username
+		}
+	}
+	return dummy
+}
+
+func (e entry) slow() bool {
+	return e.scheme == schemeBcrypt || e.scheme == schemeArgon2
+}
+
+func parseHtpasswd(data []byte) (map[string]entry, error) {
+	users := make(map[string]entry)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", lineNumber)
+		}
+
+		s, err := detectScheme(parts[1])
+		if err != nil || s == schemeArgon2 {
+			return nil, fmt.Errorf("line %d: unsupported password hash of user %q", lineNumber, parts[0])
+		}
+		users[parts[0]] = entry{scheme: s, hash: parts[1]}
+	}
+
+	return users, scanner.Err()
+}
+
+func parseJSON(data []byte) (map[string]entry, error) {
+	var hashes map[string]string
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]entry, len(hashes))
+	for username, hash := range hashes {
+		s, err := detectScheme(hash)
+		if err != nil || (s != schemeBcrypt && s != schemeArgon2) {
+			return nil, fmt.Errorf("unsupported password hash of user %q", username)
+		}
+		if s == schemeArgon2 {
+			if _, err := parseArgon2(hash); err != nil {
+				return nil, fmt.Errorf("invalid password hash of user %q: %v", username, err)
+			}
+		}
+		users[username] = entry{scheme: s, hash: hash}
+	}
+
+	return users, nil
+}
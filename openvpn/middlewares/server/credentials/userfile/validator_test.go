@@ -0,0 +1,167 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package userfile
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.NoError(t, err)
+	return string(hash)
+}
+
+func argon2Hash(password string) string {
+	salt := []byte("saltsaltsalt")
+	hash := argon2.IDKey([]byte(password), salt, 1, 1024, 1, 32)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=1024,t=1,p=1$%s$%s",
+		argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	)
+}
+
+func Test_HtpasswdValidator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "htpasswd")
+	writeFile(t, path, fmt.Sprintf(`# comment
+bcrypt:%s
+sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+apr:$apr1$xyzsalt$2kqqXzYgVpbpz.SGmIK7J0
+`, bcryptHash(t, "secret")), time.Unix(1000, 0))
+
+	validator, err := NewHtpasswdValidator(path)
+	assert.NoError(t, err)
+
+	for _, username := range []string{"bcrypt", "sha", "apr"} {
+		valid, err := validator.Validate(1, username, "secret")
+		assert.NoError(t, err, username)
+		assert.True(t, valid, username)
+
+		valid, err = validator.Validate(1, username, "wrong")
+		assert.NoError(t, err, username)
+		assert.False(t, valid, username)
+	}
+
+	valid, err := validator.Validate(1, "nobody", "secret")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+}
+
+func Test_UnknownUsersAreVerifiedAgainstDummyHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "htpasswd")
+	bcrypted := bcryptHash(t, "secret")
+	writeFile(t, path, fmt.Sprintf("apr:$apr1$xyzsalt$2kqqXzYgVpbpz.SGmIK7J0\nzed:%s\nbob:%s\n", bcryptHash(t, "other"), bcrypted), time.Unix(1000, 0))
+
+	validator, err := NewHtpasswdValidator(path)
+	assert.NoError(t, err)
+	assert.Equal(t, &entry{scheme: schemeBcrypt, hash: bcrypted}, validator.dummy)
+
+	// password matching the dummy hash does not let unknown user in
+	valid, err := validator.Validate(1, "nobody", "secret")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+}
+
+func Test_HtpasswdValidatorRejectsUnsupportedHashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "htpasswd")
+	writeFile(t, path, "des:rl0uE2ZH8A2YA\n", time.Unix(1000, 0))
+
+	_, err = NewHtpasswdValidator(path)
+	assert.Error(t, err)
+}
+
+func Test_JSONValidatorRejectsUnsafeArgon2Parameters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "users")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "users.json")
+	valid := argon2Hash("secret")
+	for _, params := range []string{"m=1024,t=0,p=1", "m=1024,t=1,p=0", "m=1024,t=1,p=256", "m=4294967295,t=1,p=1", "m=4,t=1,p=1"} {
+		writeFile(t, path, fmt.Sprintf(`{"alice": %q}`, strings.Replace(valid, "m=1024,t=1,p=1", params, 1)), time.Unix(1000, 0))
+		_, err = NewJSONValidator(path)
+		assert.Error(t, err, params)
+	}
+
+	writeFile(t, path, fmt.Sprintf(`{"alice": %q}`, valid[:strings.LastIndex(valid, "$")+1]), time.Unix(1000, 0))
+	_, err = NewJSONValidator(path)
+	assert.EqualError(t, err, fmt.Sprintf(`invalid users file %s: invalid password hash of user "alice": empty argon2 hash`, path))
+}
+
+func Test_JSONValidatorReloadsChangedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "users")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "users.json")
+	writeFile(t, path, fmt.Sprintf(`{"alice": %q, "bob": %q}`, bcryptHash(t, "alice-pw"), argon2Hash("bob-pw")), time.Unix(1000, 0))
+
+	validator, err := NewJSONValidator(path)
+	assert.NoError(t, err)
+
+	valid, _ := validator.Validate(1, "alice", "alice-pw")
+	assert.True(t, valid)
+	valid, _ = validator.Validate(1, "bob", "bob-pw")
+	assert.True(t, valid)
+	valid, _ = validator.Validate(1, "bob", "alice-pw")
+	assert.False(t, valid)
+
+	writeFile(t, path, fmt.Sprintf(`{"bob": %q}`, argon2Hash("new-pw")), time.Unix(2000, 0))
+
+	valid, _ = validator.Validate(1, "alice", "alice-pw")
+	assert.False(t, valid)
+	valid, _ = validator.Validate(1, "bob", "new-pw")
+	assert.True(t, valid)
+
+	// broken file keeps previous users
+	writeFile(t, path, `{"bob":`, time.Unix(3000, 0))
+	valid, _ = validator.Validate(1, "bob", "new-pw")
+	assert.True(t, valid)
+}
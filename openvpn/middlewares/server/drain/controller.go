@@ -0,0 +1,250 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package drain
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+)
+
+const (
+	defaultBatchSize = 10
+	defaultInterval  = time.Second
+	defaultMessage   = "server restarting"
+)
+
+var (
+	// ErrDeadlineExceeded is returned when clients are still connected at the drain deadline.
+	ErrDeadlineExceeded = errors.New("drain deadline exceeded")
+	// ErrCancelled is returned when drain is cancelled by Resume.
+	ErrCancelled = errors.New("drain cancelled")
+	// ErrInProgress is returned when drain is started while another one is running.
+	ErrInProgress = errors.New("drain already in progress")
+)
+
+// Config configures draining.
+type Config struct {
+	// BatchSize is the number of clients asked to reconnect at once, 10 by default.
+	BatchSize int
+	// Interval between batches, 1 second by default.
+	Interval time.Duration
+	// Deadline ends draining even when clients are still connected, zero waits until all clients leave.
+	Deadline time.Duration
+	// Message denies new clients while draining, "server restarting" by default.
+	Message string
+}
+
+// Progress reports the state of draining.
+type Progress struct {
+	// Total is the number of clients connected when draining started.
+	Total int
+	// Killed is the number of clients asked to reconnect so far.
+	Killed int
+	// Remaining is the number of clients still connected.
+	Remaining int
+	// Done is set on the last report.
+	Done bool
+}
+
+// ProgressCallback is called on every drain step.
+type ProgressCallback func(progress Progress)
+
+// Controller moves clients off the server before maintenance: while draining new clients are denied
+// and connected clients are asked to reconnect (client-kill RESTART) in batches.
+//
+// New clients are denied by Check, which should be passed to the credentials middleware.
+type Controller struct {
+	*auth.Middleware
+
+	config     Config
+	onProgress ProgressCallback
+
+	mu       sync.Mutex
+	draining bool
+	cancel   chan struct{}
+	changed  chan struct{}
+}
+
+// NewController creates drain controller, progress callback is optional.
+func NewController(config Config, onProgress ProgressCallback) *Controller {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	if config.Message == "" {
+		config.Message = defaultMessage
+	}
+	c := &Controller{
+		config:     config,
+		onProgress: onProgress,
+		changed:    make(chan struct{}, 1),
+	}
+	c.Middleware = auth.NewMiddleware(c.handleClientEvent)
+	return c
+}
+
+// Check denies new clients while draining, it conforms to credentials.Check callback.
+// Connected clients renegotiating keys (REAUTH) are not denied, they are drained gracefully.
+func (c *Controller) Check(event server.ClientEvent) error {
+	if event.EventType == server.Connect && c.Draining() {
+		return errors.New(c.config.Message)
+	}
+	return nil
+}
+
+// Draining tells if new clients are being denied.
+func (c *Controller) Draining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.draining
+}
+
+// Resume accepts new clients again, running drain is cancelled.
+func (c *Controller) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.draining = false
+	if c.cancel != nil {
+		close(c.cancel)
+		c.cancel = nil
+	}
+}
+
+// Drain denies new clients and asks connected ones to reconnect, blocking until all of them are gone.
+// New clients stay denied after drain completes, until Resume is called.
+func (c *Controller) Drain() error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.mu.Unlock()
+		return ErrInProgress
+	}
+	c.draining = true
+	cancel := make(chan struct{})
+	c.cancel = cancel
+	c.mu.Unlock()
+
+	err := c.drain(cancel)
+
+	c.mu.Lock()
+	if c.cancel == cancel {
+		c.cancel = nil
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *Controller) drain(cancel <-chan struct{}) error {
+	var deadline <-chan time.Time
+	if c.config.Deadline > 0 {
+		timer := time.NewTimer(c.config.Deadline)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	progress := Progress{Total: len(c.Clients())}
+	killed := make(map[int]bool)
+	// gone are clients which were not found by client-kill, their DISCONNECT was missed
+	gone := make(map[int]bool)
+	nextBatch := true
+	for {
+		var clients []auth.Client
+		for _, client := range c.Clients() {
+			if !gone[client.ClientID] {
+				clients = append(clients, client)
+			}
+		}
+		progress.Remaining = len(clients)
+		if progress.Remaining == 0 {
+			progress.Done = true
+			c.report(progress)
+			return nil
+		}
+
+		if nextBatch {
+			nextBatch = false
+			batch, missing := 0, false
+			for _, client := range clients {
+				if batch == c.config.BatchSize {
+					break
+				}
+				if killed[client.ClientID] {
+					continue
+				}
+				batch++
+				count, err := c.ClientKillWithReason(client.ClientID, auth.KillRestart, "")
+				if err != nil {
+					// retried with the next batch
+					log.Error("Unable to kill client:", client.ClientID, err)
+					continue
+				}
+				killed[client.ClientID] = true
+				if count == 0 {
+					gone[client.ClientID] = true
+					missing = true
+					continue
+				}
+				progress.Killed++
+			}
+			if missing {
+				continue
+			}
+		}
+		c.report(progress)
+
+		select {
+		case <-ticker.C:
+			nextBatch = true
+		case <-c.changed:
+		case <-deadline:
+			progress.Done = true
+			c.report(progress)
+			return ErrDeadlineExceeded
+		case <-cancel:
+			return ErrCancelled
+		}
+	}
+}
+
+func (c *Controller) report(progress Progress) {
+	if c.onProgress != nil {
+		c.onProgress(progress)
+	}
+}
+
+func (c *Controller) handleClientEvent(event server.ClientEvent) {
+	if event.EventType != server.Disconnect {
+		return
+	}
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package drain
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+type channelConnection chan string
+
+func (c channelConnection) SingleLineCommand(template string, args ...interface{}) (string, error) {
+	c <- fmt.Sprintf(template, args...)
+	return "", nil
+}
+
+func (c channelConnection) MultiLineCommand(template string, args ...interface{}) (string, []string, error) {
+	_, err := c.SingleLineCommand(template, args...)
+	return "", nil, err
+}
+
+func expectCommand(t *testing.T, commands channelConnection, expected string) {
+	select {
+	case command := <-commands:
+		assert.Equal(t, expected, command)
+	case <-time.After(time.Second):
+		t.Fatal("expected command:", expected)
+	}
+}
+
+func consume(t *testing.T, c *Controller, lines ...string) {
+	for _, line := range lines {
+		_, err := c.ConsumeLine(line)
+		require.NoError(t, err, line)
+	}
+}
+
+func establish(t *testing.T, c *Controller, clientIDs ...int) {
+	for _, id := range clientIDs {
+		consume(t, c, fmt.Sprintf(">CLIENT:ESTABLISHED,%d", id), ">CLIENT:ENV,END")
+	}
+}
+
+func disconnect(t *testing.T, c *Controller, clientID int) {
+	consume(t, c, fmt.Sprintf(">CLIENT:DISCONNECT,%d", clientID), ">CLIENT:ENV,END")
+}
+
+// failingConnection fails client-kill commands with scripted errors, in order.
+type failingConnection struct {
+	channelConnection
+
+	mu     sync.Mutex
+	errors map[string][]error
+}
+
+func (c *failingConnection) SingleLineCommand(template string, args ...interface{}) (string, error) {
+	c.channelConnection.SingleLineCommand(template, args...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	command := fmt.Sprintf(template, args...)
+	if errs := c.errors[command]; len(errs) > 0 {
+		c.errors[command] = errs[1:]
+		return "", errs[0]
+	}
+	return "", nil
+}
+
+type progressRecorder struct {
+	mu       sync.Mutex
+	progress []Progress
+}
+
+func (r *progressRecorder) record(p Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress = append(r.progress, p)
+}
+
+func (r *progressRecorder) last() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress[len(r.progress)-1]
+}
+
+func Test_DrainKillsClientsInBatches(t *testing.T) {
+	commands := make(channelConnection, 10)
+	recorder := &progressRecorder{}
+	c := NewController(Config{BatchSize: 2, Interval: 50 * time.Millisecond}, recorder.record)
+	c.Start(commands)
+	establish(t, c, 1, 2, 3)
+
+	result := make(chan error)
+	go func() { result <- c.Drain() }()
+
+	expectCommand(t, commands, `client-kill 1 "RESTART"`)
+	expectCommand(t, commands, `client-kill 2 "RESTART"`)
+	assert.True(t, c.Draining())
+	assert.EqualError(t, c.Check(server.ClientEvent{EventType: server.Connect}), "server restarting")
+	assert.NoError(t, c.Check(server.ClientEvent{EventType: server.Reauth, ClientID: 3}))
+	assert.Equal(t, ErrInProgress, c.Drain())
+
+	disconnect(t, c, 1)
+	disconnect(t, c, 2)
+	expectCommand(t, commands, `client-kill 3 "RESTART"`)
+	disconnect(t, c, 3)
+
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("drain did not complete")
+	}
+	assert.Equal(t, Progress{Total: 3, Killed: 3, Remaining: 0, Done: true}, recorder.last())
+	assert.True(t, c.Draining(), "new clients are denied until resumed")
+
+	c.Resume()
+	assert.NoError(t, c.Check(server.ClientEvent{EventType: server.Connect}))
+}
+
+func Test_DrainStopsAtDeadline(t *testing.T) {
+	commands := make(channelConnection, 10)
+	recorder := &progressRecorder{}
+	c := NewController(Config{Interval: 10 * time.Millisecond, Deadline: 100 * time.Millisecond, Message: "maintenance"}, recorder.record)
+	c.Start(commands)
+	establish(t, c, 1)
+
+	assert.Equal(t, ErrDeadlineExceeded, c.Drain())
+	expectCommand(t, commands, `client-kill 1 "RESTART"`)
+	assert.Equal(t, Progress{Total: 1, Killed: 1, Remaining: 1, Done: true}, recorder.last())
+	assert.EqualError(t, c.Check(server.ClientEvent{EventType: server.Connect}), "maintenance")
+}
+
+func Test_ResumeCancelsDrain(t *testing.T) {
+	commands := make(channelConnection, 10)
+	c := NewController(Config{Interval: 10 * time.Millisecond}, nil)
+	c.Start(commands)
+	establish(t, c, 1)
+
+	result := make(chan error)
+	go func() { result <- c.Drain() }()
+	expectCommand(t, commands, `client-kill 1 "RESTART"`)
+	c.Resume()
+
+	select {
+	case err := <-result:
+		assert.Equal(t, ErrCancelled, err)
+	case <-time.After(time.Second):
+		t.Fatal("drain was not cancelled")
+	}
+	assert.False(t, c.Draining())
+}
+
+func Test_DrainWithoutClientsCompletesImmediately(t *testing.T) {
+	c := NewController(Config{}, nil)
+	c.Start(make(channelConnection, 1))
+	assert.NoError(t, c.Drain())
+}
+
+func Test_DrainRetriesFailedKillsAndSkipsMissingClients(t *testing.T) {
+	commands := &failingConnection{
+		channelConnection: make(channelConnection, 10),
+		errors: map[string][]error{
+			`client-kill 1 "RESTART"`: {errors.New("command error: client-kill command failed")},
+			`client-kill 2 "RESTART"`: {errors.New("connection reset")},
+		},
+	}
+	recorder := &progressRecorder{}
+	c := NewController(Config{Interval: 10 * time.Millisecond}, recorder.record)
+	c.Start(commands)
+	establish(t, c, 1, 2)
+
+	result := make(chan error)
+	go func() { result <- c.Drain() }()
+
+	expectCommand(t, commands.channelConnection, `client-kill 1 "RESTART"`)
+	expectCommand(t, commands.channelConnection, `client-kill 2 "RESTART"`)
+	expectCommand(t, commands.channelConnection, `client-kill 2 "RESTART"`)
+	disconnect(t, c, 2)
+
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("drain did not complete")
+	}
+	assert.Equal(t, Progress{Total: 2, Killed: 1, Remaining: 0, Done: true}, recorder.last())
+}
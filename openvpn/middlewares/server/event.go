@@ -33,6 +33,8 @@ const (
 	Disconnect = ClientEventType("DISCONNECT")
 	// Address represent a ADDRESS OpenVPN event type.
 	Address = ClientEventType("ADDRESS")
+	// CRResponse represent a CR_RESPONSE OpenVPN event type.
+	CRResponse = ClientEventType("CR_RESPONSE")
 
 	//Env is a pseudo event type ENV - that means some of above defined events are multiline and ENV messages are part of it
 	Env = ClientEventType("ENV")
@@ -40,12 +42,23 @@ const (
 	Undefined = -1
 )
 
+// VirtualAddress is a client address reported by ADDRESS event.
+type VirtualAddress struct {
+	Address string
+	Primary bool
+}
+
 // ClientEvent represent a OpenVPN management client event.
 type ClientEvent struct {
 	EventType ClientEventType
 	ClientID  int
 	ClientKey int
 	Env       map[string]string
+
+	// Address is set for ADDRESS events only.
+	Address VirtualAddress
+	// Response is a decoded client answer of CR_RESPONSE events.
+	Response string
 }
 
 // UndefinedEvent is an empty OpenVPN management client event.
@@ -0,0 +1,138 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+// ByteCountEvent is an event type of exported byte count samples.
+const ByteCountEvent = "BYTECOUNT"
+
+// redactedFields are never exported, regardless of the allow-list.
+var redactedFields = map[string]bool{
+	"password": true,
+}
+
+// Record is a single exported JSON line.
+type Record struct {
+	Time      time.Time         `json:"time"`
+	Event     string            `json:"event"`
+	ClientID  int               `json:"client_id"`
+	ClientKey *int              `json:"client_key,omitempty"`
+	BytesIn   *uint64           `json:"bytes_in,omitempty"`
+	BytesOut  *uint64           `json:"bytes_out,omitempty"`
+	Address   string            `json:"address,omitempty"`
+	Primary   *bool             `json:"primary,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Middleware exports client events and byte counts as JSON lines.
+// Challenge/response answers are never exported.
+//
+// Session byte counts should be fed to HandleByteCount, i.e. by the bytecount middleware.
+type Middleware struct {
+	*auth.Middleware
+
+	fields map[string]bool
+	now    func() time.Time
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewMiddleware creates new instance of Middleware writing to the writer.
+// Only environment variables listed in fields are exported, nil fields export whole environment.
+// Password is never exported.
+func NewMiddleware(writer io.Writer, fields []string) *Middleware {
+	m := &Middleware{
+		now:     time.Now,
+		encoder: json.NewEncoder(writer),
+	}
+	if fields != nil {
+		m.fields = make(map[string]bool, len(fields))
+		for _, field := range fields {
+			m.fields[field] = true
+		}
+	}
+	m.Middleware = auth.NewMiddleware(m.handleClientEvent)
+	return m
+}
+
+// HandleByteCount exports session byte count sample.
+func (m *Middleware) HandleByteCount(count bytecount.SessionByteCount) {
+	m.write(Record{
+		Time:     m.now(),
+		Event:    ByteCountEvent,
+		ClientID: count.ClientID,
+		BytesIn:  &count.BytesIn,
+		BytesOut: &count.BytesOut,
+	})
+}
+
+func (m *Middleware) handleClientEvent(event server.ClientEvent) {
+	record := Record{
+		Time:     m.now(),
+		Event:    string(event.EventType),
+		ClientID: event.ClientID,
+		Env:      m.filter(event.Env),
+	}
+	if event.ClientKey != server.Undefined {
+		key := event.ClientKey
+		record.ClientKey = &key
+	}
+	if event.EventType == server.Address {
+		record.Address = event.Address.Address
+		record.Primary = &event.Address.Primary
+	}
+
+	m.write(record)
+}
+
+func (m *Middleware) filter(env map[string]string) map[string]string {
+	filtered := make(map[string]string)
+	for key, value := range env {
+		if redactedFields[key] {
+			continue
+		}
+		if m.fields != nil && !m.fields[key] {
+			continue
+		}
+		filtered[key] = value
+	}
+	return filtered
+}
+
+func (m *Middleware) write(record Record) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.encoder.Encode(record); err != nil {
+		log.Error("Unable to export client event:", err)
+	}
+}
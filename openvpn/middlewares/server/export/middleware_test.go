@@ -0,0 +1,81 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+func newTestMiddleware(fields []string) (*Middleware, *bytes.Buffer) {
+	var buffer bytes.Buffer
+	middleware := NewMiddleware(&buffer, fields)
+	middleware.now = func() time.Time { return time.Unix(1000, 0).UTC() }
+	middleware.Start(&management.MockConnection{})
+	return middleware, &buffer
+}
+
+func Test_ExportsClientEventsAndByteCounts(t *testing.T) {
+	middleware, buffer := newTestMiddleware(nil)
+
+	for _, line := range []string{
+		">CLIENT:CONNECT,1,2",
+		">CLIENT:ENV,username=alice",
+		">CLIENT:ENV,password=secret",
+		">CLIENT:ENV,END",
+		">CLIENT:ESTABLISHED,1",
+		">CLIENT:ENV,END",
+		">CLIENT:ADDRESS,1,10.8.0.6,1",
+		">CLIENT:CR_RESPONSE,1,3,MTIzNDU2",
+		">CLIENT:ENV,END",
+	} {
+		middleware.ConsumeLine(line)
+	}
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 10, BytesOut: 20})
+
+	assert.Equal(t, `{"time":"1970-01-01T00:16:40Z","event":"CONNECT","client_id":1,"client_key":2,"env":{"username":"alice"}}
+{"time":"1970-01-01T00:16:40Z","event":"ESTABLISHED","client_id":1}
+{"time":"1970-01-01T00:16:40Z","event":"ADDRESS","client_id":1,"address":"10.8.0.6","primary":true}
+{"time":"1970-01-01T00:16:40Z","event":"CR_RESPONSE","client_id":1,"client_key":3}
+{"time":"1970-01-01T00:16:40Z","event":"BYTECOUNT","client_id":1,"bytes_in":10,"bytes_out":20}
+`, buffer.String())
+}
+
+func Test_ExportsOnlyAllowedFields(t *testing.T) {
+	middleware, buffer := newTestMiddleware([]string{"common_name", "password"})
+
+	for _, line := range []string{
+		">CLIENT:DISCONNECT,1",
+		">CLIENT:ENV,common_name=alice",
+		">CLIENT:ENV,password=secret",
+		">CLIENT:ENV,untrusted_ip=1.1.1.1",
+		">CLIENT:ENV,END",
+	} {
+		middleware.ConsumeLine(line)
+	}
+
+	assert.Equal(t, `{"time":"1970-01-01T00:16:40Z","event":"DISCONNECT","client_id":1,"env":{"common_name":"alice"}}
+`, buffer.String())
+}
@@ -21,7 +21,7 @@ package filter
 
 import (
 	"bytes"
-	"html/template"
+	"text/template"
 
 	"github.com/trevor403/go-openvpn-static/openvpn/log"
 	"github.com/trevor403/go-openvpn-static/openvpn/management"
@@ -29,20 +29,26 @@ import (
 	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
 )
 
-const filterLANTemplate = `client-pf {{.ClientID}}
-[CLIENTS DROP]
-[SUBNETS ACCEPT]
-{{- range $subnet := .Allow}}
+const filterTemplate = `client-pf {{.ClientID}}
+[CLIENTS {{.Rules.ClientsDefault}}]
+{{- range $client := .Rules.AllowClients}}
++{{$client}}
+{{- end}}
+{{- range $client := .Rules.BlockClients}}
+-{{$client}}
+{{- end}}
+[SUBNETS {{.Rules.SubnetsDefault}}]
+{{- range $subnet := .Rules.AllowSubnets}}
 +{{$subnet}}
 {{- end}}
-{{- range $subnet := .Block}}
+{{- range $subnet := .Rules.BlockSubnets}}
 -{{$subnet}}
 {{- end}}
 [END]
 END
 `
 
-var filterLAN = template.Must(template.New("filter_lan").Parse(filterLANTemplate))
+var filter = template.Must(template.New("filter").Parse(filterTemplate))
 
 // Exposes API to control client's packet filtering.
 //
@@ -54,16 +60,25 @@ type middleware struct {
 	*auth.Middleware
 
 	commandWriter management.CommandWriter
-	allow         []string
-	block         []string
+	policy        Policy
 }
 
-// NewMiddleware creates new instance of middleware
+// NewMiddleware creates new instance of middleware which drops client-to-client traffic
+// and applies the same subnet rules to every client.
 func NewMiddleware(allow, block []string) *middleware {
+	return NewPolicyMiddleware(StaticPolicy(Rules{
+		ClientsDefault: Drop,
+		SubnetsDefault: Accept,
+		AllowSubnets:   allow,
+		BlockSubnets:   block,
+	}))
+}
+
+// NewPolicyMiddleware creates new instance of middleware which asks policy for rules of each client.
+func NewPolicyMiddleware(policy Policy) *middleware {
 	m := new(middleware)
 	m.Middleware = auth.NewMiddleware(m.handleClientEvent)
-	m.allow = allow
-	m.block = block
+	m.policy = policy
 	return m
 }
 
@@ -72,32 +87,47 @@ func (m *middleware) Start(commandWriter management.CommandWriter) error {
 	return m.Middleware.Start(commandWriter)
 }
 
+// UpdateClient replaces packet filter of already connected client.
+func (m *middleware) UpdateClient(clientID int, rules Rules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	return applyRules(m.commandWriter, clientID, rules)
+}
+
 func (m *middleware) handleClientEvent(event server.ClientEvent) {
 	switch event.EventType {
 	case server.Connect, server.Reauth:
-		if err := filterSubnets(m.commandWriter, event.ClientID, m.allow, m.block); err != nil {
-			log.Error("Unable to authenticate client:", err)
+		rules, err := m.policy(event)
+		if err == nil {
+			err = rules.Validate()
+		}
+		if err != nil {
+			log.Error("Invalid packet filter for client:", event.ClientID, err)
+			rules = DropAll
+		}
+
+		if err := applyRules(m.commandWriter, event.ClientID, rules); err != nil {
+			log.Error("Unable to apply packet filter:", err)
 		}
 	}
 }
 
-func filterSubnets(commandWriter management.CommandWriter, clientID int, allow, block []string) error {
+func applyRules(commandWriter management.CommandWriter, clientID int, rules Rules) error {
 	data := struct {
 		ClientID int
-		Allow    []string
-		Block    []string
+		Rules    Rules
 	}{
 		ClientID: clientID,
-		Allow:    allow,
-		Block:    block,
+		Rules:    rules,
 	}
 
 	var tpl bytes.Buffer
-	if err := filterLAN.Execute(&tpl, data); err != nil {
+	if err := filter.Execute(&tpl, data); err != nil {
 		return err
 	}
 
-	_, err := commandWriter.SingleLineCommand(tpl.String())
+	_, err := commandWriter.SingleLineCommand("%s", tpl.String())
 
 	return err
 }
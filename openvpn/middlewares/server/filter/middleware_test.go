@@ -100,3 +100,69 @@ func Test_BothPacketFilterForBothSubnets(t *testing.T) {
 	middleware.handleClientEvent(server.ClientEvent{EventType: server.Connect, ClientID: 0})
 	assert.Equal(t, bothFilter, mockConnection.WrittenLines[0])
 }
+
+func Test_PolicyReceivesClientEvent(t *testing.T) {
+	var received server.ClientEvent
+	middleware := NewPolicyMiddleware(func(event server.ClientEvent) (Rules, error) {
+		received = event
+		return Rules{
+			ClientsDefault: Drop,
+			AllowClients:   []string{"o'brien"},
+			BlockClients:   []string{"mallory"},
+			SubnetsDefault: Drop,
+			AllowSubnets:   []string{"10.0.0.0/8"},
+		}, nil
+	})
+	mockConnection := &management.MockConnection{}
+	middleware.Start(mockConnection)
+
+	event := server.ClientEvent{EventType: server.Connect, ClientID: 5, Env: map[string]string{"common_name": "alice"}}
+	middleware.handleClientEvent(event)
+
+	assert.Equal(t, event, received)
+	assert.Equal(t, `client-pf 5
+[CLIENTS DROP]
++o'brien
+-mallory
+[SUBNETS DROP]
++10.0.0.0/8
+[END]
+END
+`, mockConnection.LastLine)
+}
+
+func Test_InvalidPolicyRulesDropAllTraffic(t *testing.T) {
+	middleware := NewMiddleware([]string{"1.1.1.1"}, nil)
+	mockConnection := &management.MockConnection{}
+	middleware.Start(mockConnection)
+
+	middleware.handleClientEvent(server.ClientEvent{EventType: server.Connect, ClientID: 0})
+	assert.Equal(t, `client-pf 0
+[CLIENTS DROP]
+[SUBNETS DROP]
+[END]
+END
+`, mockConnection.LastLine)
+}
+
+func Test_UpdateClient(t *testing.T) {
+	middleware := NewMiddleware(nil, nil)
+	mockConnection := &management.MockConnection{}
+	middleware.Start(mockConnection)
+
+	err := middleware.UpdateClient(7, Rules{ClientsDefault: Accept, SubnetsDefault: Accept, BlockSubnets: []string{"3.3.3.3/32"}})
+	assert.NoError(t, err)
+	assert.Equal(t, `client-pf 7
+[CLIENTS ACCEPT]
+[SUBNETS ACCEPT]
+-3.3.3.3/32
+[END]
+END
+`, mockConnection.LastLine)
+
+	err = middleware.UpdateClient(7, Rules{ClientsDefault: Accept, SubnetsDefault: Accept, AllowSubnets: []string{"bad"}})
+	assert.Error(t, err)
+	err = middleware.UpdateClient(7, Rules{ClientsDefault: Accept, SubnetsDefault: Accept, AllowClients: []string{"bad\nname"}})
+	assert.Error(t, err)
+	assert.Len(t, mockConnection.WrittenLines, 1)
+}
@@ -0,0 +1,114 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+// Action is a default packet filter action of a rules section.
+type Action string
+
+const (
+	// Accept lets packets through unless a rule says otherwise.
+	Accept = Action("ACCEPT")
+	// Drop discards packets unless a rule says otherwise.
+	Drop = Action("DROP")
+)
+
+// Rules is a packet filter document of a single client.
+//
+// Clients are matched by common name, subnets are given in CIDR notation.
+type Rules struct {
+	ClientsDefault Action
+	AllowClients   []string
+	BlockClients   []string
+
+	SubnetsDefault Action
+	AllowSubnets   []string
+	BlockSubnets   []string
+}
+
+// Policy callback builds packet filter rules for the given client event.
+type Policy func(event server.ClientEvent) (Rules, error)
+
+// StaticPolicy returns a policy which applies the same rules to every client.
+func StaticPolicy(rules Rules) Policy {
+	return func(_ server.ClientEvent) (Rules, error) {
+		return rules, nil
+	}
+}
+
+// DropAll is a rules document which isolates the client completely.
+var DropAll = Rules{
+	ClientsDefault: Drop,
+	SubnetsDefault: Drop,
+}
+
+// Validate checks that rules can be safely sent to OpenVPN.
+func (r Rules) Validate() error {
+	if err := validateAction(r.ClientsDefault); err != nil {
+		return fmt.Errorf("invalid clients action: %v", err)
+	}
+	if err := validateAction(r.SubnetsDefault); err != nil {
+		return fmt.Errorf("invalid subnets action: %v", err)
+	}
+
+	for _, clients := range [][]string{r.AllowClients, r.BlockClients} {
+		for _, name := range clients {
+			if err := validateCommonName(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, subnets := range [][]string{r.AllowSubnets, r.BlockSubnets} {
+		for _, subnet := range subnets {
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				return fmt.Errorf("invalid subnet %q: %v", subnet, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateAction(action Action) error {
+	switch action {
+	case Accept, Drop:
+		return nil
+	}
+	return errors.New("unknown action: " + string(action))
+}
+
+func validateCommonName(name string) error {
+	if name == "" {
+		return errors.New("empty client common name")
+	}
+	if strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("invalid client common name %q", name)
+	}
+	return nil
+}
@@ -20,6 +20,7 @@
 package server
 
 import (
+	"encoding/base64"
 	"errors"
 	"regexp"
 	"strconv"
@@ -30,6 +31,8 @@ var (
 	ruleID          = regexp.MustCompile(`^(\d+)$`)
 	ruleIDAndKey    = regexp.MustCompile(`^(\d+),(\d+)$`)
 	ruleClientEvent = regexp.MustCompile(`^(\w+),(.*)$`)
+	ruleAddress     = regexp.MustCompile(`^(\d+),([^,]+),([01])$`)
+	ruleCRResponse  = regexp.MustCompile(`^(\d+),(\d+),(.*)$`)
 )
 
 // ParseClientEvent parses OpenVPN management client event.
@@ -89,3 +92,38 @@ func ParseID(data string) (int, error) {
 
 	return ID, nil
 }
+
+// ParseAddress parses CID and virtual address of ADDRESS event.
+func ParseAddress(data string) (int, VirtualAddress, error) {
+	match := ruleAddress.FindStringSubmatch(data)
+	if len(match) < 4 {
+		return Undefined, VirtualAddress{}, errors.New("unable to parse address: " + data)
+	}
+
+	ID, err := strconv.Atoi(match[1])
+	if err != nil {
+		return Undefined, VirtualAddress{}, err
+	}
+
+	return ID, VirtualAddress{Address: match[2], Primary: match[3] == "1"}, nil
+}
+
+// ParseCRResponse parses CID, KID and base64 decoded response of CR_RESPONSE event.
+func ParseCRResponse(data string) (int, int, string, error) {
+	match := ruleCRResponse.FindStringSubmatch(data)
+	if len(match) < 4 {
+		return Undefined, Undefined, "", errors.New("unable to parse challenge response: " + data)
+	}
+
+	ID, key, err := ParseIDAndKey(match[1] + "," + match[2])
+	if err != nil {
+		return Undefined, Undefined, "", err
+	}
+
+	response, err := base64.StdEncoding.DecodeString(match[3])
+	if err != nil {
+		return Undefined, Undefined, "", errors.New("unable to decode challenge response: " + err.Error())
+	}
+
+	return ID, key, string(response), nil
+}
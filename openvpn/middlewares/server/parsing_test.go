@@ -109,3 +109,37 @@ func TestIDIsParsed(t *testing.T) {
 	}
 
 }
+
+func TestAddressIsParsed(t *testing.T) {
+	var testData = []struct {
+		testLine string
+		ID       int
+		address  VirtualAddress
+		err      error
+	}{
+		{"1,10.8.0.6,1", 1, VirtualAddress{Address: "10.8.0.6", Primary: true}, nil},
+		{"12,fdde:1::1000,0", 12, VirtualAddress{Address: "fdde:1::1000", Primary: false}, nil},
+		{"1,10.8.0.6", Undefined, VirtualAddress{}, errors.New("unable to parse address: 1,10.8.0.6")},
+		{"a,10.8.0.6,1", Undefined, VirtualAddress{}, errors.New("unable to parse address: a,10.8.0.6,1")},
+	}
+
+	for _, test := range testData {
+		ID, address, err := ParseAddress(test.testLine)
+		assert.Equal(t, test.ID, ID, test.testLine)
+		assert.Equal(t, test.address, address, test.testLine)
+		assert.Equal(t, test.err, err, test.testLine)
+	}
+}
+
+func TestCRResponseIsParsed(t *testing.T) {
+	ID, key, response, err := ParseCRResponse("3,4,MTIzNDU2")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, ID)
+	assert.Equal(t, 4, key)
+	assert.Equal(t, "123456", response)
+
+	_, _, _, err = ParseCRResponse("3,4,!!!")
+	assert.Error(t, err)
+	_, _, _, err = ParseCRResponse("3,MTIzNDU2")
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package policy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+)
+
+const replacedMessage = "HALT,session replaced by a new connection"
+
+// ErrDenied is returned to clients denied by the policy, the detailed reason is only logged,
+// as it may reveal the policy, i.e. allowed networks.
+var ErrDenied = errors.New("access denied by policy")
+
+// Engine evaluates policy rules for connecting clients.
+//
+// Engine tracks established sessions by client events, so it has to be registered as a middleware.
+// Check should be passed to the credentials middleware, so that the policy is consulted before credentials are validated.
+// Sessions replaced by the client are killed only once the client is ESTABLISHED, so that failed logins
+// do not disconnect anyone.
+type Engine struct {
+	*auth.Middleware
+
+	rule     Rule
+	sessions *Sessions
+	now      func() time.Time
+
+	mu          sync.Mutex
+	replacement map[int][]int
+}
+
+// NewEngine creates new instance of Engine.
+func NewEngine(rule Rule) *Engine {
+	e := &Engine{
+		rule:        rule,
+		sessions:    NewSessions(),
+		now:         time.Now,
+		replacement: make(map[int][]int),
+	}
+	e.Middleware = auth.NewMiddleware(e.handleClientEvent)
+	return e
+}
+
+// Sessions returns sessions tracked by the engine.
+func (e *Engine) Sessions() *Sessions {
+	return e.sessions
+}
+
+// Evaluate evaluates the policy for the client event.
+func (e *Engine) Evaluate(event server.ClientEvent) Decision {
+	return e.rule.Evaluate(Request{
+		Event:    event,
+		Info:     event.Info(),
+		Time:     e.now(),
+		Sessions: e.sessions,
+	})
+}
+
+// Check consults the policy, it conforms to credentials.Check callback.
+// Sessions which have to be replaced by the client are killed when the client is established.
+// Denied clients get ErrDenied, the reason of the decision is only logged.
+func (e *Engine) Check(event server.ClientEvent) error {
+	decision := e.Evaluate(event)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !decision.Allow {
+		delete(e.replacement, event.ClientID)
+		log.Info("Policy denied client with ID:", event.ClientID, "because", decision.Reason)
+		return ErrDenied
+	}
+
+	log.Info("Policy allowed client with ID:", event.ClientID, "because", decision.Reason)
+	if len(decision.Kill) > 0 {
+		e.replacement[event.ClientID] = decision.Kill
+	} else {
+		delete(e.replacement, event.ClientID)
+	}
+	return nil
+}
+
+func (e *Engine) handleClientEvent(event server.ClientEvent) {
+	e.sessions.HandleClientEvent(event, e.now())
+
+	e.mu.Lock()
+	replaced := e.replacement[event.ClientID]
+	switch event.EventType {
+	case server.Established, server.Disconnect:
+		delete(e.replacement, event.ClientID)
+	}
+	e.mu.Unlock()
+
+	if event.EventType != server.Established {
+		return
+	}
+	for _, clientID := range replaced {
+		if _, ok := e.sessions.Get(clientID); !ok || clientID == event.ClientID {
+			continue
+		}
+		log.Info("Policy kills client with ID:", clientID, "replaced by client with ID:", event.ClientID)
+		if err := e.ClientKillWithMessage(clientID, replacedMessage); err != nil {
+			log.Error("Unable to kill client:", err)
+		}
+	}
+}
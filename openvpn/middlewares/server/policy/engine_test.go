@@ -0,0 +1,103 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/credentials"
+)
+
+func Test_EngineWithCredentialsMiddleware(t *testing.T) {
+	source, _ := SourceIn("10.0.0.0/8")
+	engine := NewEngine(All(source, DuplicateCN(KillOlder)))
+	authenticator := credentials.NewMiddleware(nil, engine.Check)
+
+	mockConnection := &management.MockConnection{}
+	engine.Start(mockConnection)
+	authenticator.Start(mockConnection)
+
+	consume := func(lines ...string) {
+		for _, line := range lines {
+			engine.ConsumeLine(line)
+			authenticator.ConsumeLine(line)
+		}
+	}
+
+	consume(">CLIENT:CONNECT,1,1", ">CLIENT:ENV,common_name=alice", ">CLIENT:ENV,untrusted_ip=10.0.0.1", ">CLIENT:ENV,END")
+	assert.Equal(t, "client-auth-nt 1 1", mockConnection.LastLine)
+	consume(">CLIENT:ESTABLISHED,1", ">CLIENT:ENV,common_name=alice", ">CLIENT:ENV,END")
+	assert.Len(t, engine.Sessions().All(), 1)
+
+	consume(">CLIENT:CONNECT,2,1", ">CLIENT:ENV,common_name=alice", ">CLIENT:ENV,untrusted_ip=1.1.1.1", ">CLIENT:ENV,END")
+	assert.Equal(t, `client-deny 2 1 "access denied by policy" "access denied by policy"`, mockConnection.LastLine)
+
+	consume(">CLIENT:CONNECT,3,1", ">CLIENT:ENV,common_name=alice", ">CLIENT:ENV,untrusted_ip=10.0.0.2", ">CLIENT:ENV,END")
+	assert.Equal(t, []string{"client-auth-nt 3 1"}, mockConnection.WrittenLines[2:])
+	consume(">CLIENT:ESTABLISHED,3", ">CLIENT:ENV,common_name=alice", ">CLIENT:ENV,END")
+	assert.Equal(t, []string{"client-auth-nt 3 1", `client-kill 1 "HALT,session replaced by a new connection"`}, mockConnection.WrittenLines[2:])
+
+	consume(">CLIENT:DISCONNECT,1", ">CLIENT:ENV,END", ">CLIENT:DISCONNECT,3", ">CLIENT:ENV,END")
+	assert.Empty(t, engine.Sessions().All())
+}
+
+func Test_EngineDoesNotKillForFailedLogin(t *testing.T) {
+	engine := NewEngine(DuplicateCN(KillOlder))
+	authenticator := credentials.NewMiddleware(func(clientID int, username, password string) (bool, error) {
+		return password == "secret", nil
+	}, engine.Check)
+
+	mockConnection := &management.MockConnection{}
+	engine.Start(mockConnection)
+	authenticator.Start(mockConnection)
+
+	consume := func(lines ...string) {
+		for _, line := range lines {
+			engine.ConsumeLine(line)
+			authenticator.ConsumeLine(line)
+		}
+	}
+
+	consume(">CLIENT:ESTABLISHED,1", ">CLIENT:ENV,common_name=alice", ">CLIENT:ENV,END")
+	consume(
+		">CLIENT:CONNECT,2,1",
+		">CLIENT:ENV,common_name=alice",
+		">CLIENT:ENV,username=alice",
+		">CLIENT:ENV,password=guess",
+		">CLIENT:ENV,END",
+		">CLIENT:DISCONNECT,2",
+		">CLIENT:ENV,END",
+	)
+	assert.Equal(t, []string{`client-deny 2 1 "wrong username or password" "wrong username or password"`}, mockConnection.WrittenLines)
+	assert.Len(t, engine.Sessions().All(), 1)
+}
+
+func Test_EngineEvaluate(t *testing.T) {
+	engine := NewEngine(MaxSessions(1))
+	engine.handleClientEvent(server.ClientEvent{EventType: server.Established, ClientID: 1, Env: map[string]string{"username": "alice"}})
+
+	decision := engine.Evaluate(server.ClientEvent{EventType: server.Connect, ClientID: 2, Env: map[string]string{"username": "alice"}})
+	assert.False(t, decision.Allow)
+	decision = engine.Evaluate(server.ClientEvent{EventType: server.Connect, ClientID: 2, Env: map[string]string{"username": "bob"}})
+	assert.True(t, decision.Allow)
+}
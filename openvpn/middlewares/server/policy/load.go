@@ -0,0 +1,191 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// spec is a serialized rule, exactly one of its fields has to be set, i.e.
+//
+//	{"all": [
+//	  {"any": [{"source": ["10.0.0.0/8"]}, {"time": {"from": "08:00", "to": "18:00", "days": ["mon", "fri"]}}]},
+//	  {"not": {"source": ["10.6.6.0/24"]}},
+//	  {"max_sessions": 2},
+//	  {"duplicate_cn": "kill_older"}
+//	]}
+type spec struct {
+	All         []spec    `json:"all" yaml:"all"`
+	Any         []spec    `json:"any" yaml:"any"`
+	Not         *spec     `json:"not" yaml:"not"`
+	Source      []string  `json:"source" yaml:"source"`
+	Time        *timeSpec `json:"time" yaml:"time"`
+	MaxSessions *int      `json:"max_sessions" yaml:"max_sessions"`
+	DuplicateCN string    `json:"duplicate_cn" yaml:"duplicate_cn"`
+}
+
+type timeSpec struct {
+	From     string   `json:"from" yaml:"from"`
+	To       string   `json:"to" yaml:"to"`
+	Days     []string `json:"days" yaml:"days"`
+	Location string   `json:"location" yaml:"location"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// LoadJSON builds a rule from JSON policy.
+func LoadJSON(data []byte) (Rule, error) {
+	var s spec
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, err
+	}
+	return s.rule()
+}
+
+// LoadYAML builds a rule from YAML policy.
+func LoadYAML(data []byte) (Rule, error) {
+	var s spec
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return nil, err
+	}
+	return s.rule()
+}
+
+// LoadFile builds a rule from policy file, files with .yaml or .yml extension are parsed as YAML, others as JSON.
+func LoadFile(path string) (Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return LoadYAML(data)
+	default:
+		return LoadJSON(data)
+	}
+}
+
+func (s spec) rule() (Rule, error) {
+	set := 0
+	for _, isSet := range []bool{s.All != nil, s.Any != nil, s.Not != nil, s.Source != nil, s.Time != nil, s.MaxSessions != nil, s.DuplicateCN != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, errors.New("policy rule must define exactly one of: all, any, not, source, time, max_sessions, duplicate_cn")
+	}
+
+	switch {
+	case s.All != nil:
+		rules, err := rules(s.All)
+		return All(rules...), err
+	case s.Any != nil:
+		rules, err := rules(s.Any)
+		return Any(rules...), err
+	case s.Not != nil:
+		rule, err := s.Not.rule()
+		if err != nil {
+			return nil, err
+		}
+		return Not(rule), nil
+	case s.Source != nil:
+		return SourceIn(s.Source...)
+	case s.Time != nil:
+		return s.Time.rule()
+	case s.MaxSessions != nil:
+		if *s.MaxSessions < 0 {
+			return nil, errors.New("max_sessions must not be negative")
+		}
+		return MaxSessions(*s.MaxSessions), nil
+	default:
+		switch mode := DuplicateMode(s.DuplicateCN); mode {
+		case KillOlder, DenyNewer:
+			return DuplicateCN(mode), nil
+		}
+		return nil, fmt.Errorf("unknown duplicate_cn mode: %q", s.DuplicateCN)
+	}
+}
+
+func rules(specs []spec) ([]Rule, error) {
+	rules := make([]Rule, 0, len(specs))
+	for _, s := range specs {
+		rule, err := s.rule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (s timeSpec) rule() (Rule, error) {
+	from, err := parseTimeOfDay(s.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTimeOfDay(s.To)
+	if err != nil {
+		return nil, err
+	}
+
+	window := TimeWindow{From: from, To: to}
+	for _, day := range s.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day: %q", day)
+		}
+		window.Days = append(window.Days, weekday)
+	}
+	if s.Location != "" {
+		if window.Location, err = time.LoadLocation(s.Location); err != nil {
+			return nil, err
+		}
+	}
+	return window, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
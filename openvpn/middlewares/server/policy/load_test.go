@@ -0,0 +1,96 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const jsonPolicy = `{"all": [
+	{"any": [{"source": ["10.0.0.0/8"]}, {"time": {"from": "08:00", "to": "18:00", "days": ["mon"], "location": "UTC"}}]},
+	{"not": {"source": ["10.6.6.0/24"]}},
+	{"max_sessions": 2}
+]}`
+
+const yamlPolicy = `
+all:
+  - any:
+      - source: ["10.0.0.0/8"]
+      - time: {from: "08:00", to: "18:00", days: [mon], location: UTC}
+  - not:
+      source: ["10.6.6.0/24"]
+  - max_sessions: 2
+`
+
+func Test_LoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "policy.json"), []byte(jsonPolicy), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "policy.yml"), []byte(yamlPolicy), 0600))
+
+	monday := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	for _, file := range []string{"policy.json", "policy.yml"} {
+		rule, err := LoadFile(filepath.Join(dir, file))
+		assert.NoError(t, err, file)
+
+		assert.True(t, rule.Evaluate(request("10.1.1.1", monday.Add(12*time.Hour))).Allow, file)
+		assert.True(t, rule.Evaluate(request("1.1.1.1", monday)).Allow, file)
+		assert.False(t, rule.Evaluate(request("1.1.1.1", monday.Add(12*time.Hour))).Allow, file)
+		assert.False(t, rule.Evaluate(request("10.6.6.6", monday)).Allow, file)
+	}
+}
+
+func Test_LoadInvalidPolicy(t *testing.T) {
+	var policies = []string{
+		`{}`,
+		`{"source": ["10.0.0.0/8"], "max_sessions": 1}`,
+		`{"source": ["bad"]}`,
+		`{"time": {"from": "8am", "to": "18:00"}}`,
+		`{"time": {"from": "08:00", "to": "18:00", "days": ["someday"]}}`,
+		`{"duplicate_cn": "whatever"}`,
+		`{"all": [{"max_sessions": -1}]}`,
+		`{"unknown_rule": 1}`,
+		`{"time": {"from": "08:00", "to": "18:00", "timezone": "UTC"}}`,
+	}
+
+	for _, policy := range policies {
+		_, err := LoadJSON([]byte(policy))
+		assert.Error(t, err, policy)
+	}
+
+	_, err := LoadYAML([]byte("unknown_rule: 1"))
+	assert.Error(t, err)
+}
+
+func Test_LoadZeroMaxSessions(t *testing.T) {
+	for _, load := range []func([]byte) (Rule, error){LoadJSON, LoadYAML} {
+		rule, err := load([]byte(`{"max_sessions": 0}`))
+		assert.NoError(t, err)
+		assert.Equal(t, MaxSessions(0), rule)
+	}
+}
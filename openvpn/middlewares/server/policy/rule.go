@@ -0,0 +1,238 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package policy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+// Decision is a result of rule evaluation.
+type Decision struct {
+	Allow bool
+	// Reason explains the decision.
+	Reason string
+	// Kill lists clients which have to be disconnected for the request to be admitted.
+	Kill []int
+}
+
+// Request is a client authorization request evaluated by rules.
+type Request struct {
+	Event    server.ClientEvent
+	Info     server.ClientInfo
+	Time     time.Time
+	Sessions *Sessions
+}
+
+// Rule decides whether a client should be admitted.
+type Rule interface {
+	Evaluate(request Request) Decision
+}
+
+// RuleFunc is an adapter to use ordinary functions as rules.
+type RuleFunc func(request Request) Decision
+
+// Evaluate calls f(request).
+func (f RuleFunc) Evaluate(request Request) Decision {
+	return f(request)
+}
+
+func allow(format string, args ...interface{}) Decision {
+	return Decision{Allow: true, Reason: fmt.Sprintf(format, args...)}
+}
+
+func deny(format string, args ...interface{}) Decision {
+	return Decision{Allow: false, Reason: fmt.Sprintf(format, args...)}
+}
+
+// All allows the request when every rule allows it.
+func All(rules ...Rule) Rule {
+	return RuleFunc(func(request Request) Decision {
+		var reasons []string
+		var kill []int
+		for _, rule := range rules {
+			decision := rule.Evaluate(request)
+			if !decision.Allow {
+				return decision
+			}
+			reasons = append(reasons, decision.Reason)
+			kill = append(kill, decision.Kill...)
+		}
+		return Decision{Allow: true, Reason: strings.Join(reasons, " and "), Kill: kill}
+	})
+}
+
+// Any allows the request when at least one of rules allows it.
+func Any(rules ...Rule) Rule {
+	return RuleFunc(func(request Request) Decision {
+		var reasons []string
+		for _, rule := range rules {
+			decision := rule.Evaluate(request)
+			if decision.Allow {
+				return decision
+			}
+			reasons = append(reasons, decision.Reason)
+		}
+		return Decision{Allow: false, Reason: strings.Join(reasons, " and ")}
+	})
+}
+
+// Not inverts the decision of the rule.
+func Not(rule Rule) Rule {
+	return RuleFunc(func(request Request) Decision {
+		decision := rule.Evaluate(request)
+		return Decision{Allow: !decision.Allow, Reason: "not (" + decision.Reason + ")", Kill: decision.Kill}
+	})
+}
+
+// SourceIn allows clients connecting from any of given subnets or addresses.
+func SourceIn(subnets ...string) (Rule, error) {
+	var networks []*net.IPNet
+	for _, subnet := range subnets {
+		if !strings.Contains(subnet, "/") {
+			if ip := net.ParseIP(subnet); ip != nil && ip.To4() != nil {
+				subnet += "/32"
+			} else {
+				subnet += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return RuleFunc(func(request Request) Decision {
+		ip := request.Info.RemoteIP
+		if ip == nil {
+			return deny("source address is unknown")
+		}
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return allow("source %s is in %s", ip, network)
+			}
+		}
+		return deny("source %s is not in %s", ip, strings.Join(subnets, ", "))
+	}), nil
+}
+
+// TimeWindow allows clients connecting during given time of day.
+// Window may span midnight, i.e. from 22:00 to 06:00. No days mean every day.
+type TimeWindow struct {
+	From, To time.Duration
+	Days     []time.Weekday
+	Location *time.Location
+}
+
+// Evaluate checks whether request time falls into the window.
+func (w TimeWindow) Evaluate(request Request) Decision {
+	now := request.Time
+	if w.Location != nil {
+		now = now.In(w.Location)
+	}
+	sinceMidnight := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+
+	inWindow := sinceMidnight >= w.From && sinceMidnight < w.To
+	day := now.Weekday()
+	if w.To <= w.From {
+		inWindow = sinceMidnight >= w.From || sinceMidnight < w.To
+		if sinceMidnight < w.To {
+			// early morning part belongs to the window which started the day before
+			day = (day + 6) % 7
+		}
+	}
+
+	window := fmt.Sprintf("%s-%s", formatTimeOfDay(w.From), formatTimeOfDay(w.To))
+	if !inWindow {
+		return deny("access is allowed only during %s", window)
+	}
+	if len(w.Days) > 0 && !containsDay(w.Days, day) {
+		return deny("access is not allowed on %s", day)
+	}
+	return allow("time %s is within %s", now.Format("15:04"), window)
+}
+
+// MaxSessions allows users having less than limit established sessions.
+type MaxSessions int
+
+// Evaluate checks number of other sessions of the user.
+func (limit MaxSessions) Evaluate(request Request) Decision {
+	username := request.Info.Username
+	count := 0
+	for _, session := range request.Sessions.ByUsername(username) {
+		if session.ClientID != request.Event.ClientID {
+			count++
+		}
+	}
+	if count >= int(limit) {
+		return deny("maximum number of sessions (%d) reached", int(limit))
+	}
+	return allow("user %s has %d of %d sessions", username, count, int(limit))
+}
+
+// DuplicateMode defines how clients reusing already connected common name are handled.
+type DuplicateMode string
+
+const (
+	// KillOlder disconnects already connected clients with the same common name.
+	KillOlder = DuplicateMode("kill_older")
+	// DenyNewer denies new clients with the same common name.
+	DenyNewer = DuplicateMode("deny_newer")
+)
+
+// DuplicateCN handles clients reusing common name of already connected client.
+type DuplicateCN DuplicateMode
+
+// Evaluate checks for other sessions with the same common name.
+func (mode DuplicateCN) Evaluate(request Request) Decision {
+	commonName := request.Info.CommonName
+
+	var duplicates []int
+	for _, session := range request.Sessions.ByCommonName(commonName) {
+		if session.ClientID != request.Event.ClientID {
+			duplicates = append(duplicates, session.ClientID)
+		}
+	}
+	if len(duplicates) == 0 {
+		return allow("common name %s is not connected", commonName)
+	}
+	if DuplicateMode(mode) == KillOlder {
+		return Decision{Allow: true, Reason: fmt.Sprintf("common name %s replaces older sessions", commonName), Kill: duplicates}
+	}
+	return deny("common name %s is already connected", commonName)
+}
+
+func containsDay(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
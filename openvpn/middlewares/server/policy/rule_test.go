@@ -0,0 +1,103 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package policy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+func request(ip string, at time.Time) Request {
+	return Request{
+		Event:    server.ClientEvent{EventType: server.Connect, ClientID: 10},
+		Info:     server.ClientInfo{Username: "alice", CommonName: "alice-laptop", RemoteIP: net.ParseIP(ip)},
+		Time:     at,
+		Sessions: NewSessions(),
+	}
+}
+
+func Test_SourceIn(t *testing.T) {
+	rule, err := SourceIn("10.0.0.0/8", "1.2.3.4", "2001:db8::/32")
+	assert.NoError(t, err)
+
+	assert.True(t, rule.Evaluate(request("10.1.2.3", time.Now())).Allow)
+	assert.True(t, rule.Evaluate(request("1.2.3.4", time.Now())).Allow)
+	assert.True(t, rule.Evaluate(request("2001:db8::1", time.Now())).Allow)
+
+	decision := rule.Evaluate(request("1.2.3.5", time.Now()))
+	assert.Equal(t, Decision{Reason: "source 1.2.3.5 is not in 10.0.0.0/8, 1.2.3.4, 2001:db8::/32"}, decision)
+
+	_, err = SourceIn("not-an-ip")
+	assert.Error(t, err)
+}
+
+func Test_TimeWindow(t *testing.T) {
+	office := TimeWindow{From: 8 * time.Hour, To: 18 * time.Hour, Days: []time.Weekday{time.Monday}}
+	monday := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	assert.True(t, office.Evaluate(request("", monday.Add(9*time.Hour))).Allow)
+	assert.Equal(t, "access is allowed only during 08:00-18:00", office.Evaluate(request("", monday.Add(19*time.Hour))).Reason)
+	assert.Equal(t, "access is not allowed on Tuesday", office.Evaluate(request("", monday.Add(33*time.Hour))).Reason)
+
+	night := TimeWindow{From: 22 * time.Hour, To: 6 * time.Hour, Days: []time.Weekday{time.Monday}}
+	assert.True(t, night.Evaluate(request("", monday.Add(23*time.Hour))).Allow)
+	assert.True(t, night.Evaluate(request("", monday.Add(29*time.Hour))).Allow)
+	assert.False(t, night.Evaluate(request("", monday.Add(5*time.Hour))).Allow)
+	assert.False(t, night.Evaluate(request("", monday.Add(12*time.Hour))).Allow)
+}
+
+func Test_SessionRules(t *testing.T) {
+	req := request("1.1.1.1", time.Now())
+	req.Sessions.Add(Session{ClientID: 1, Username: "alice", CommonName: "alice-laptop", Since: time.Unix(1, 0)})
+	req.Sessions.Add(Session{ClientID: 2, Username: "alice", CommonName: "alice-phone", Since: time.Unix(2, 0)})
+
+	assert.True(t, MaxSessions(3).Evaluate(req).Allow)
+	assert.Equal(t, Decision{Reason: "maximum number of sessions (2) reached"}, MaxSessions(2).Evaluate(req))
+
+	// the session itself is not counted on REAUTH
+	req.Event.ClientID = 2
+	assert.True(t, MaxSessions(2).Evaluate(req).Allow)
+
+	req.Event.ClientID = 10
+	assert.Equal(t, Decision{Reason: "common name alice-laptop is already connected"}, DuplicateCN(DenyNewer).Evaluate(req))
+	decision := DuplicateCN(KillOlder).Evaluate(req)
+	assert.True(t, decision.Allow)
+	assert.Equal(t, []int{1}, decision.Kill)
+}
+
+func Test_Combinators(t *testing.T) {
+	yes := RuleFunc(func(Request) Decision { return Decision{Allow: true, Reason: "yes", Kill: []int{1}} })
+	no := RuleFunc(func(Request) Decision { return Decision{Reason: "no"} })
+	req := request("1.1.1.1", time.Now())
+
+	assert.Equal(t, Decision{Allow: true, Reason: "yes and yes", Kill: []int{1, 1}}, All(yes, yes).Evaluate(req))
+	assert.Equal(t, Decision{Reason: "no"}, All(yes, no).Evaluate(req))
+	assert.Equal(t, Decision{Allow: true, Reason: "yes", Kill: []int{1}}, Any(no, yes).Evaluate(req))
+	assert.Equal(t, Decision{Reason: "no and no"}, Any(no, no).Evaluate(req))
+	assert.Equal(t, Decision{Reason: "not (yes)", Kill: []int{1}}, Not(yes).Evaluate(req))
+	assert.Equal(t, Decision{Allow: true, Reason: "not (no)"}, Not(no).Evaluate(req))
+
+	replacing := RuleFunc(func(Request) Decision { return Decision{Reason: "replaces", Kill: []int{7}} })
+	assert.Equal(t, Decision{Allow: true, Reason: "not (replaces)", Kill: []int{7}}, Not(replacing).Evaluate(req))
+}
@@ -0,0 +1,125 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package policy
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+// Session describes an established client session.
+type Session struct {
+	ClientID   int
+	Username   string
+	CommonName string
+	Since      time.Time
+}
+
+// Sessions keeps track of established client sessions.
+type Sessions struct {
+	mu       sync.RWMutex
+	sessions map[int]Session
+}
+
+// NewSessions creates new instance of Sessions.
+func NewSessions() *Sessions {
+	return &Sessions{sessions: make(map[int]Session)}
+}
+
+// Add starts tracking of the session.
+func (s *Sessions) Add(session Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ClientID] = session
+}
+
+// Remove stops tracking of the client session.
+func (s *Sessions) Remove(clientID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, clientID)
+}
+
+// Get returns tracked session of the client.
+func (s *Sessions) Get(clientID int) (Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[clientID]
+	return session, ok
+}
+
+// HandleClientEvent tracks sessions by client events.
+func (s *Sessions) HandleClientEvent(event server.ClientEvent, now time.Time) {
+	switch event.EventType {
+	case server.Established:
+		s.Add(Session{
+			ClientID:   event.ClientID,
+			Username:   event.Env["username"],
+			CommonName: event.Env["common_name"],
+			Since:      now,
+		})
+	case server.Disconnect:
+		s.Remove(event.ClientID)
+	}
+}
+
+// ByUsername returns sessions of the user, oldest first.
+func (s *Sessions) ByUsername(username string) []Session {
+	return s.filter(func(session Session) bool {
+		return username != "" && session.Username == username
+	})
+}
+
+// ByCommonName returns sessions of the common name, oldest first.
+func (s *Sessions) ByCommonName(commonName string) []Session {
+	return s.filter(func(session Session) bool {
+		return commonName != "" && session.CommonName == commonName
+	})
+}
+
+// All returns all sessions, oldest first.
+func (s *Sessions) All() []Session {
+	return s.filter(func(Session) bool { return true })
+}
+
+func (s *Sessions) filter(match func(Session) bool) []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []Session
+	for _, session := range s.sessions {
+		if match(session) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Since.Equal(sessions[j].Since) {
+			return sessions[i].ClientID < sessions[j].ClientID
+		}
+		return sessions[i].Since.Before(sessions[j].Since)
+	})
+	return sessions
+}
@@ -0,0 +1,211 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package quota
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+const killMessage = "HALT,data quota exceeded"
+
+// ErrQuotaExceeded is returned by Check when user has no data left.
+var ErrQuotaExceeded = errors.New("data quota exceeded")
+
+// Warning is reported when user usage crosses one of configured thresholds.
+type Warning struct {
+	Username  string
+	ClientID  int
+	Used      uint64
+	Limit     uint64
+	Threshold float64
+}
+
+// WarningCallback is called when user usage crosses a warning threshold.
+type WarningCallback func(Warning)
+
+type session struct {
+	username string
+	bytes    uint64
+	killed   bool
+}
+
+// Middleware enforces per user data quotas.
+//
+// Session byte counts should be fed to HandleByteCount, i.e. by the bytecount middleware.
+// To deny new connections of users over their quota, Check should be passed to the credentials middleware.
+type Middleware struct {
+	*auth.Middleware
+
+	store      Store
+	thresholds []float64
+	onWarning  WarningCallback
+
+	mu       sync.Mutex
+	sessions map[int]*session
+	warned   map[string]float64
+}
+
+// NewMiddleware creates new instance of Middleware.
+// Thresholds are fractions of the limit (i.e. 0.8 for 80%) at which onWarning is called.
+func NewMiddleware(store Store, thresholds []float64, onWarning WarningCallback) *Middleware {
+	sorted := append([]float64(nil), thresholds...)
+	sort.Float64s(sorted)
+
+	m := &Middleware{
+		store:      store,
+		thresholds: sorted,
+		onWarning:  onWarning,
+		sessions:   make(map[int]*session),
+		warned:     make(map[string]float64),
+	}
+	m.Middleware = auth.NewMiddleware(m.handleClientEvent)
+	return m
+}
+
+// Check denies users who have exhausted their quota.
+func (m *Middleware) Check(event server.ClientEvent) error {
+	username := event.Env["username"]
+	if username == "" {
+		return nil
+	}
+
+	used, limit, err := m.usage(username)
+	if err != nil {
+		log.Error("Unable to get data usage of user:", username, err)
+		return nil
+	}
+	if limit > 0 && used >= limit {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// HandleByteCount accounts session byte count sample to the session owner.
+// The store, the warning callback and the kill command are called without holding the middleware lock.
+func (m *Middleware) HandleByteCount(count bytecount.SessionByteCount) {
+	m.mu.Lock()
+	s, ok := m.sessions[count.ClientID]
+	if !ok || s.killed {
+		m.mu.Unlock()
+		return
+	}
+
+	total := count.BytesIn + count.BytesOut
+	delta := total - s.bytes
+	if total < s.bytes {
+		// counters were reset, everything reported belongs to the new session
+		delta = total
+	}
+	s.bytes = total
+	username := s.username
+	m.mu.Unlock()
+
+	used, err := m.store.AddUsage(username, delta)
+	if err != nil {
+		log.Error("Unable to store data usage of user:", username, err)
+		return
+	}
+	limit, err := m.store.Limit(username)
+	if err != nil {
+		log.Error("Unable to get data limit of user:", username, err)
+		return
+	}
+	if limit == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	warning := m.warning(username, count.ClientID, used, limit)
+	kill := used >= limit && m.sessions[count.ClientID] == s && !s.killed
+	if kill {
+		s.killed = true
+	}
+	m.mu.Unlock()
+
+	if warning != nil && m.onWarning != nil {
+		m.onWarning(*warning)
+	}
+	if kill {
+		log.Info("Data quota exceeded, killing client with ID:", count.ClientID, "user:", username)
+		if err := m.ClientKillWithMessage(count.ClientID, killMessage); err != nil {
+			log.Error("Unable to kill client:", err)
+		}
+	}
+}
+
+// warning returns the warning to report when usage crossed a new threshold, it must be called with m.mu held.
+func (m *Middleware) warning(username string, clientID int, used, limit uint64) *Warning {
+	ratio := float64(used) / float64(limit)
+
+	crossed := 0.0
+	for _, threshold := range m.thresholds {
+		if ratio >= threshold {
+			crossed = threshold
+		}
+	}
+	if crossed <= m.warned[username] {
+		if crossed == 0 {
+			delete(m.warned, username)
+		}
+		return nil
+	}
+	m.warned[username] = crossed
+
+	return &Warning{
+		Username:  username,
+		ClientID:  clientID,
+		Used:      used,
+		Limit:     limit,
+		Threshold: crossed,
+	}
+}
+
+func (m *Middleware) usage(username string) (uint64, uint64, error) {
+	used, err := m.store.Usage(username)
+	if err != nil {
+		return 0, 0, err
+	}
+	limit, err := m.store.Limit(username)
+	if err != nil {
+		return 0, 0, err
+	}
+	return used, limit, nil
+}
+
+func (m *Middleware) handleClientEvent(event server.ClientEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch event.EventType {
+	case server.Established:
+		if username := event.Env["username"]; username != "" {
+			m.sessions[event.ClientID] = &session{username: username}
+		}
+	case server.Disconnect:
+		delete(m.sessions, event.ClientID)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+func established(clientID int, username string) server.ClientEvent {
+	return server.ClientEvent{
+		EventType: server.Established,
+		ClientID:  clientID,
+		Env:       map[string]string{"username": username},
+	}
+}
+
+func Test_UsageIsAccumulatedAcrossSessions(t *testing.T) {
+	store := NewMemoryStore()
+	middleware := NewMiddleware(store, nil, nil)
+	middleware.Start(&management.MockConnection{})
+
+	middleware.handleClientEvent(established(1, "alice"))
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 10, BytesOut: 20})
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 15, BytesOut: 25})
+	middleware.handleClientEvent(server.ClientEvent{EventType: server.Disconnect, ClientID: 1})
+
+	middleware.handleClientEvent(established(2, "alice"))
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 2, BytesIn: 5, BytesOut: 5})
+	// counters were reset
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 2, BytesIn: 1, BytesOut: 1})
+
+	used, err := store.Usage("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(52), used)
+}
+
+func Test_WarningsAndKillWhenQuotaExceeded(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetLimit("alice", 100)
+
+	var warnings []Warning
+	middleware := NewMiddleware(store, []float64{0.9, 0.5}, func(w Warning) {
+		warnings = append(warnings, w)
+	})
+	mockConnection := &management.MockConnection{}
+	middleware.Start(mockConnection)
+
+	middleware.handleClientEvent(established(1, "alice"))
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 30, BytesOut: 30})
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 35, BytesOut: 35})
+	assert.Equal(t, []Warning{{Username: "alice", ClientID: 1, Used: 60, Limit: 100, Threshold: 0.5}}, warnings)
+	assert.Empty(t, mockConnection.WrittenLines)
+
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 50, BytesOut: 50})
+	assert.Len(t, warnings, 2)
+	assert.Equal(t, 0.9, warnings[1].Threshold)
+	assert.Equal(t, []string{`client-kill 1 "HALT,data quota exceeded"`}, mockConnection.WrittenLines)
+
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 60, BytesOut: 60})
+	assert.Len(t, mockConnection.WrittenLines, 1)
+}
+
+func Test_WarningCallbackMayCallMiddleware(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetLimit("alice", 100)
+
+	var middleware *Middleware
+	middleware = NewMiddleware(store, []float64{1}, func(w Warning) {
+		// i.e. the session is ended by the callback
+		middleware.handleClientEvent(server.ClientEvent{EventType: server.Disconnect, ClientID: w.ClientID})
+	})
+	mockConnection := &management.MockConnection{}
+	middleware.Start(mockConnection)
+	middleware.handleClientEvent(established(1, "alice"))
+
+	done := make(chan struct{})
+	go func() {
+		middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 50, BytesOut: 50})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("warning callback deadlocked")
+	}
+	assert.Equal(t, []string{`client-kill 1 "HALT,data quota exceeded"`}, mockConnection.WrittenLines)
+}
+
+func Test_CheckDeniesUsersOverQuota(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetLimit("alice", 100)
+	store.AddUsage("alice", 100)
+	store.AddUsage("bob", 100)
+	middleware := NewMiddleware(store, nil, nil)
+
+	err := middleware.Check(server.ClientEvent{EventType: server.Connect, Env: map[string]string{"username": "alice"}})
+	assert.Equal(t, ErrQuotaExceeded, err)
+
+	err = middleware.Check(server.ClientEvent{EventType: server.Connect, Env: map[string]string{"username": "bob"}})
+	assert.NoError(t, err)
+
+	store.ResetUsage("alice")
+	err = middleware.Check(server.ClientEvent{EventType: server.Connect, Env: map[string]string{"username": "alice"}})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,88 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package quota
+
+import "sync"
+
+// Store keeps cumulative data usage and data limits of users.
+type Store interface {
+	// Limit returns data limit of the user in bytes, zero means unlimited.
+	Limit(username string) (uint64, error)
+	// Usage returns amount of bytes the user has transferred so far.
+	Usage(username string) (uint64, error)
+	// AddUsage adds transferred bytes to the user and returns updated usage.
+	AddUsage(username string, bytes uint64) (uint64, error)
+}
+
+// MemoryStore is a Store which keeps usage in memory.
+type MemoryStore struct {
+	mu     sync.Mutex
+	limits map[string]uint64
+	usage  map[string]uint64
+}
+
+// NewMemoryStore creates new instance of MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		limits: make(map[string]uint64),
+		usage:  make(map[string]uint64),
+	}
+}
+
+// SetLimit sets data limit of the user in bytes, zero means unlimited.
+func (s *MemoryStore) SetLimit(username string, limit uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits[username] = limit
+}
+
+// ResetUsage forgets usage of the user, i.e. when a new billing period starts.
+func (s *MemoryStore) ResetUsage(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.usage, username)
+}
+
+// Limit returns data limit of the user in bytes.
+func (s *MemoryStore) Limit(username string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.limits[username], nil
+}
+
+// Usage returns amount of bytes the user has transferred so far.
+func (s *MemoryStore) Usage(username string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.usage[username], nil
+}
+
+// AddUsage adds transferred bytes to the user and returns updated usage.
+func (s *MemoryStore) AddUsage(username string, bytes uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.usage[username] += bytes
+	return s.usage[username], nil
+}
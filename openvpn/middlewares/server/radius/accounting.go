@@ -0,0 +1,227 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package radius
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+// Acct-Status-Type values, see RFC 2866 section 5.1.
+const (
+	StatusStart         = 1
+	StatusStop          = 2
+	StatusInterimUpdate = 3
+)
+
+const accountingQueueSize = 1024
+
+type accountingSession struct {
+	id       string
+	clientID int
+	username string
+	address  net.IP
+	calling  string
+	started  time.Time
+	lastSent time.Time
+	bytesIn  uint64
+	bytesOut uint64
+}
+
+// Accounting reports client sessions to RADIUS accounting servers as defined by RFC 2866:
+// Start on connection establishment, Interim-Update on byte count samples and Stop on disconnect.
+//
+// Session byte counts should be fed to HandleByteCount, i.e. by the bytecount middleware.
+// Packets are sent in order from a background goroutine, so that RADIUS latency does not block
+// management events.
+type Accounting struct {
+	*auth.Middleware
+
+	client  *Client
+	interim time.Duration
+	now     func() time.Time
+
+	mu       sync.Mutex
+	sessions map[int]*accountingSession
+
+	queue chan *Packet
+	done  chan struct{}
+}
+
+// NewAccounting creates RADIUS accounting reporter.
+// Interim updates are sent at most once per interim interval, zero sends one on every byte count sample.
+func NewAccounting(client *Client, interim time.Duration) *Accounting {
+	a := &Accounting{
+		client:   client,
+		interim:  interim,
+		now:      time.Now,
+		sessions: make(map[int]*accountingSession),
+	}
+	a.Middleware = auth.NewMiddleware(a.handleClientEvent)
+	return a
+}
+
+// Start starts the middleware.
+func (a *Accounting) Start(commandWriter management.CommandWriter) error {
+	a.queue = make(chan *Packet, accountingQueueSize)
+	a.done = make(chan struct{})
+	go a.send(a.queue, a.done)
+	return a.Middleware.Start(commandWriter)
+}
+
+// Stop stops the middleware, remaining sessions are reported as stopped.
+func (a *Accounting) Stop(commandWriter management.CommandWriter) error {
+	a.mu.Lock()
+	for clientID, session := range a.sessions {
+		a.enqueue(a.request(session, StatusStop))
+		delete(a.sessions, clientID)
+	}
+	queue := a.queue
+	a.queue = nil
+	a.mu.Unlock()
+
+	if queue != nil {
+		close(queue)
+		<-a.done
+	}
+	return a.Middleware.Stop(commandWriter)
+}
+
+// HandleByteCount updates session traffic and sends Interim-Update.
+func (a *Accounting) HandleByteCount(count bytecount.SessionByteCount) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	session, ok := a.sessions[count.ClientID]
+	if !ok {
+		return
+	}
+	session.bytesIn, session.bytesOut = count.BytesIn, count.BytesOut
+	if a.now().Sub(session.lastSent) < a.interim {
+		return
+	}
+	session.lastSent = a.now()
+	a.enqueue(a.request(session, StatusInterimUpdate))
+}
+
+func (a *Accounting) handleClientEvent(event server.ClientEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch event.EventType {
+	case server.Established:
+		session := &accountingSession{
+			id:       newSessionID(event.ClientID),
+			clientID: event.ClientID,
+			username: event.Env["username"],
+			address:  net.ParseIP(event.Env["ifconfig_pool_remote_ip"]),
+			started:  a.now(),
+		}
+		if ip := event.Info().RemoteIP; ip != nil {
+			session.calling = ip.String()
+		}
+		if session.username == "" {
+			session.username = event.Env["common_name"]
+		}
+		session.lastSent = session.started
+		a.sessions[event.ClientID] = session
+		a.enqueue(a.request(session, StatusStart))
+	case server.Disconnect:
+		session, ok := a.sessions[event.ClientID]
+		if !ok {
+			return
+		}
+		delete(a.sessions, event.ClientID)
+		if bytes, err := strconv.ParseUint(event.Env["bytes_received"], 10, 64); err == nil {
+			session.bytesIn = bytes
+		}
+		if bytes, err := strconv.ParseUint(event.Env["bytes_sent"], 10, 64); err == nil {
+			session.bytesOut = bytes
+		}
+		a.enqueue(a.request(session, StatusStop))
+	}
+}
+
+func (a *Accounting) request(session *accountingSession, status uint32) *Packet {
+	now := a.now()
+	request := a.client.NewRequest(AccountingRequest)
+	request.AddUint32(AcctStatusType, status)
+	request.AddString(AcctSessionID, session.id)
+	request.AddString(UserName, session.username)
+	request.AddUint32(NASPort, uint32(session.clientID))
+	request.AddUint32(NASPortType, nasPortTypeVirtual)
+	request.AddIP(FramedIPAddress, session.address)
+	if session.calling != "" {
+		request.AddString(CallingStationID, session.calling)
+	}
+	request.AddUint32(EventTimestamp, uint32(now.Unix()))
+	if status != StatusStart {
+		request.AddUint32(AcctSessionTime, uint32(now.Sub(session.started)/time.Second))
+		request.AddUint32(AcctInputOctets, uint32(session.bytesIn))
+		request.AddUint32(AcctInputGigawords, uint32(session.bytesIn>>32))
+		request.AddUint32(AcctOutputOctets, uint32(session.bytesOut))
+		request.AddUint32(AcctOutputGigawords, uint32(session.bytesOut>>32))
+	}
+	return request
+}
+
+// enqueue must be called with a.mu held.
+func (a *Accounting) enqueue(request *Packet) {
+	if a.queue == nil {
+		log.Warn("RADIUS accounting is not started, dropping request")
+		return
+	}
+	select {
+	case a.queue <- request:
+	default:
+		log.Error("RADIUS accounting queue is full, dropping request")
+	}
+}
+
+func (a *Accounting) send(queue <-chan *Packet, done chan<- struct{}) {
+	defer close(done)
+	for request := range queue {
+		response, err := a.client.Exchange(request)
+		if err != nil {
+			log.Error("RADIUS accounting failed:", err)
+			continue
+		}
+		if response.Code != AccountingResponse {
+			log.Error("Unexpected RADIUS accounting response code:", response.Code)
+		}
+	}
+}
+
+func newSessionID(clientID int) string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(random), clientID)
+}
@@ -0,0 +1,130 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package radius
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+func waitForRequests(t *testing.T, s *standIn, count int) []*Packet {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if requests := s.received(); len(requests) >= count {
+			return requests
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d accounting requests, got %d", count, len(s.received()))
+	return nil
+}
+
+func status(p *Packet) uint32 {
+	value, _ := p.GetUint32(AcctStatusType)
+	return value
+}
+
+func TestAccountingReportsSessionLifecycle(t *testing.T) {
+	s := newStandIn(t, "secret", acceptAlice)
+	defer s.close()
+
+	now := time.Unix(1600000000, 0)
+	accounting := NewAccounting(NewClient(Config{Servers: []Server{s.server()}, Timeout: time.Second}), time.Minute)
+	accounting.now = func() time.Time { return now }
+	connection := &management.MockConnection{}
+	require.NoError(t, accounting.Start(connection))
+
+	for _, line := range []string{
+		">CLIENT:ESTABLISHED,5",
+		">CLIENT:ENV,username=alice",
+		">CLIENT:ENV,untrusted_ip=192.0.2.1",
+		">CLIENT:ENV,ifconfig_pool_remote_ip=10.8.0.6",
+		">CLIENT:ENV,END",
+	} {
+		_, err := accounting.ConsumeLine(line)
+		require.NoError(t, err)
+	}
+
+	now = now.Add(30 * time.Second)
+	accounting.HandleByteCount(bytecount.SessionByteCount{ClientID: 5, BytesIn: 100, BytesOut: 200})
+	now = now.Add(40 * time.Second)
+	accounting.HandleByteCount(bytecount.SessionByteCount{ClientID: 5, BytesIn: 5000000000, BytesOut: 300})
+
+	now = now.Add(20 * time.Second)
+	for _, line := range []string{
+		">CLIENT:DISCONNECT,5",
+		">CLIENT:ENV,bytes_received=5000000100",
+		">CLIENT:ENV,bytes_sent=400",
+		">CLIENT:ENV,END",
+	} {
+		_, err := accounting.ConsumeLine(line)
+		require.NoError(t, err)
+	}
+	require.NoError(t, accounting.Stop(connection))
+
+	requests := waitForRequests(t, s, 3)
+	require.Len(t, requests, 3)
+	assert.Equal(t, []uint32{StatusStart, StatusInterimUpdate, StatusStop}, []uint32{status(requests[0]), status(requests[1]), status(requests[2])})
+
+	start := requests[0]
+	assert.Equal(t, "alice", start.GetString(UserName))
+	assert.Equal(t, "192.0.2.1", start.GetString(CallingStationID))
+	address, _ := start.Get(FramedIPAddress)
+	assert.Equal(t, net.ParseIP("10.8.0.6").To4(), net.IP(address))
+	sessionID := start.GetString(AcctSessionID)
+	assert.NotEmpty(t, sessionID)
+
+	interim := requests[1]
+	assert.Equal(t, sessionID, interim.GetString(AcctSessionID))
+	input, _ := interim.GetUint32(AcctInputOctets)
+	gigawords, _ := interim.GetUint32(AcctInputGigawords)
+	assert.Equal(t, uint64(5000000000), uint64(gigawords)<<32+uint64(input))
+
+	stop := requests[2]
+	sessionTime, _ := stop.GetUint32(AcctSessionTime)
+	assert.Equal(t, uint32(90), sessionTime)
+	output, _ := stop.GetUint32(AcctOutputOctets)
+	assert.Equal(t, uint32(400), output)
+}
+
+func TestAccountingStopsRemainingSessions(t *testing.T) {
+	s := newStandIn(t, "secret", acceptAlice)
+	defer s.close()
+
+	accounting := NewAccounting(NewClient(Config{Servers: []Server{s.server()}, Timeout: time.Second}), 0)
+	connection := &management.MockConnection{}
+	require.NoError(t, accounting.Start(connection))
+	for _, line := range []string{">CLIENT:ESTABLISHED,1", ">CLIENT:ENV,common_name=bob", ">CLIENT:ENV,END"} {
+		_, err := accounting.ConsumeLine(line)
+		require.NoError(t, err)
+	}
+	require.NoError(t, accounting.Stop(connection))
+
+	requests := s.received()
+	require.Len(t, requests, 2)
+	assert.Equal(t, uint32(StatusStop), status(requests[1]))
+	assert.Equal(t, "bob", requests[1].GetString(UserName))
+}
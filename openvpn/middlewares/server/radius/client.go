@@ -0,0 +1,202 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package radius
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultTimeout = 3 * time.Second
+
+// ErrNoResponse is returned when none of the servers answered.
+var ErrNoResponse = errors.New("no response from RADIUS servers")
+
+// Server is a RADIUS server with its shared secret.
+type Server struct {
+	// Address is host:port, i.e. "10.0.0.1:1812" for authentication or "10.0.0.1:1813" for accounting.
+	Address string
+	Secret  []byte
+}
+
+// Config configures RADIUS client.
+type Config struct {
+	// Servers are tried in order until one of them answers.
+	Servers []Server
+	// Timeout to wait for a response, 3 seconds by default.
+	Timeout time.Duration
+	// Retries is the number of retransmissions to the same server before failing over to the next one.
+	Retries int
+	// NASIdentifier and NASIPAddress identify this server to RADIUS, at least one of them should be set.
+	NASIdentifier string
+	NASIPAddress  net.IP
+}
+
+// Client exchanges RADIUS packets with servers.
+type Client struct {
+	config Config
+
+	mu         sync.Mutex
+	identifier byte
+}
+
+// NewClient creates new RADIUS client.
+func NewClient(config Config) *Client {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	}
+	return &Client{config: config}
+}
+
+// NewRequest creates request packet with NAS identification attributes.
+func (c *Client) NewRequest(code Code) *Packet {
+	request := &Packet{Code: code}
+	if c.config.NASIdentifier != "" {
+		request.AddString(NASIdentifier, c.config.NASIdentifier)
+	}
+	request.AddIP(NASIPAddress, c.config.NASIPAddress)
+	return request
+}
+
+// Exchange sends the request and waits for a verified response, failing over between servers.
+// User-Password attribute of the request must be in plain text, it is hidden with the secret of each server.
+// Access-Request packets are signed with Message-Authenticator.
+func (c *Client) Exchange(request *Packet) (*Packet, error) {
+	if len(c.config.Servers) == 0 {
+		return nil, errors.New("no RADIUS servers configured")
+	}
+
+	var authenticator [authenticatorSize]byte
+	if request.Code == AccessRequest {
+		if _, err := rand.Read(authenticator[:]); err != nil {
+			return nil, err
+		}
+	}
+	identifier := c.nextIdentifier()
+
+	lastErr := ErrNoResponse
+	for _, server := range c.config.Servers {
+		encoded, err := c.encode(request, identifier, authenticator, server.Secret)
+		if err != nil {
+			return nil, err
+		}
+		if request.Code != AccessRequest {
+			authenticator = ResponseAuthenticator(encoded, [authenticatorSize]byte{}, server.Secret)
+			copy(encoded[4:headerSize], authenticator[:])
+		}
+
+		for attempt := 0; attempt <= c.config.Retries; attempt++ {
+			response, err := c.send(server, encoded, identifier, authenticator)
+			if err == nil {
+				return response, nil
+			}
+			lastErr = fmt.Errorf("%s: %w", server.Address, err)
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				break
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w, last error: %v", ErrNoResponse, lastErr)
+}
+
+func (c *Client) nextIdentifier() byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.identifier++
+	return c.identifier
+}
+
+func (c *Client) encode(request *Packet, identifier byte, authenticator [authenticatorSize]byte, secret []byte) ([]byte, error) {
+	packet := &Packet{Code: request.Code, Identifier: identifier, Authenticator: authenticator}
+	for _, attribute := range request.Attributes {
+		switch attribute.Type {
+		case MessageAuthenticator:
+			continue
+		case UserPassword:
+			hidden, err := EncodePassword(attribute.Value, secret, authenticator)
+			if err != nil {
+				return nil, err
+			}
+			packet.Add(UserPassword, hidden)
+		default:
+			packet.Add(attribute.Type, attribute.Value)
+		}
+	}
+	if request.Code == AccessRequest {
+		packet.Add(MessageAuthenticator, make([]byte, authenticatorSize))
+	}
+
+	encoded, err := packet.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if request.Code == AccessRequest {
+		signMessage(encoded, authenticator, secret)
+	}
+	return encoded, nil
+}
+
+// send transmits encoded request and waits for the matching response, forged or stray responses are ignored.
+func (c *Client) send(server Server, encoded []byte, identifier byte, authenticator [authenticatorSize]byte) (*Packet, error) {
+	conn, err := net.Dial("udp", server.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(c.config.Timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(encoded); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		response, err := Decode(buf[:n])
+		if err != nil || response.Identifier != identifier {
+			continue
+		}
+		if response.Authenticator != ResponseAuthenticator(buf[:n], authenticator, server.Secret) {
+			continue
+		}
+		// unsigned access responses are rejected to prevent forgery (CVE-2024-3596, Blast-RADIUS)
+		if !verifyMessage(buf[:n], authenticator, server.Secret, isAccessResponse(response.Code)) {
+			continue
+		}
+		return response, nil
+	}
+}
+
+func isAccessResponse(code Code) bool {
+	return code == AccessAccept || code == AccessReject || code == AccessChallenge
+}
@@ -0,0 +1,183 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package radius
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// standIn is an in-process RADIUS server.
+type standIn struct {
+	conn   net.PacketConn
+	secret []byte
+	reply  func(request *Packet, password string) *Packet
+	// unsigned disables Message-Authenticator in access responses.
+	unsigned bool
+
+	mu       sync.Mutex
+	requests []*Packet
+}
+
+func newStandIn(t *testing.T, secret string, reply func(request *Packet, password string) *Packet) *standIn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &standIn{conn: conn, secret: []byte(secret), reply: reply}
+	go s.serve()
+	return s
+}
+
+func (s *standIn) server() Server {
+	return Server{Address: s.conn.LocalAddr().String(), Secret: s.secret}
+}
+
+func (s *standIn) close() {
+	s.conn.Close()
+}
+
+func (s *standIn) received() []*Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*Packet(nil), s.requests...)
+}
+
+func (s *standIn) serve() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request, err := Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		var password string
+		switch request.Code {
+		case AccessRequest:
+			if !verifyMessage(buf[:n], request.Authenticator, s.secret, true) {
+				continue
+			}
+			hidden, _ := request.Get(UserPassword)
+			plain, _ := DecodePassword(hidden, s.secret, request.Authenticator)
+			password = string(plain)
+		case AccountingRequest:
+			if ResponseAuthenticator(buf[:n], [16]byte{}, s.secret) != request.Authenticator {
+				continue
+			}
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, request)
+		s.mu.Unlock()
+
+		response := s.reply(request, password)
+		if response == nil {
+			continue
+		}
+		response.Identifier = request.Identifier
+		response.Authenticator = request.Authenticator
+		signed := !s.unsigned && isAccessResponse(response.Code)
+		if signed {
+			response.Add(MessageAuthenticator, make([]byte, authenticatorSize))
+		}
+		encoded, _ := response.Encode()
+		if signed {
+			signMessage(encoded, request.Authenticator, s.secret)
+		}
+		authenticator := ResponseAuthenticator(encoded, request.Authenticator, s.secret)
+		copy(encoded[4:20], authenticator[:])
+		s.conn.WriteTo(encoded, addr)
+	}
+}
+
+func acceptAlice(request *Packet, password string) *Packet {
+	switch {
+	case request.Code == AccountingRequest:
+		return &Packet{Code: AccountingResponse}
+	case request.GetString(UserName) == "alice" && password == "secret":
+		return &Packet{Code: AccessAccept}
+	default:
+		reject := &Packet{Code: AccessReject}
+		reject.AddString(ReplyMessage, "bad credentials")
+		return reject
+	}
+}
+
+func TestClientFailsOverToNextServer(t *testing.T) {
+	silent := newStandIn(t, "s1", func(*Packet, string) *Packet { return nil })
+	defer silent.close()
+	working := newStandIn(t, "s2", acceptAlice)
+	defer working.close()
+
+	client := NewClient(Config{
+		Servers: []Server{silent.server(), working.server()},
+		Timeout: 50 * time.Millisecond,
+		Retries: 1,
+	})
+	request := client.NewRequest(AccessRequest)
+	request.AddString(UserName, "alice")
+	request.AddString(UserPassword, "secret")
+
+	response, err := client.Exchange(request)
+	require.NoError(t, err)
+	assert.Equal(t, AccessAccept, response.Code)
+	assert.Len(t, silent.received(), 2, "request and one retransmission")
+	assert.Len(t, working.received(), 1)
+}
+
+func TestClientFailsWithMismatchedSecret(t *testing.T) {
+	s := newStandIn(t, "server secret", acceptAlice)
+	defer s.close()
+
+	server := s.server()
+	server.Secret = []byte("client secret")
+	client := NewClient(Config{Servers: []Server{server}, Timeout: 50 * time.Millisecond})
+	request := client.NewRequest(AccountingRequest)
+	request.AddUint32(AcctStatusType, StatusStart)
+
+	_, err := client.Exchange(request)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrNoResponse))
+}
+
+func TestClientRejectsUnsignedAccessResponse(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	forger := &standIn{conn: conn, secret: []byte("secret"), reply: acceptAlice, unsigned: true}
+	go forger.serve()
+	defer forger.close()
+
+	client := NewClient(Config{Servers: []Server{forger.server()}, Timeout: 50 * time.Millisecond})
+	request := client.NewRequest(AccessRequest)
+	request.AddString(UserName, "alice")
+	request.AddString(UserPassword, "secret")
+
+	_, err = client.Exchange(request)
+	assert.True(t, errors.Is(err, ErrNoResponse))
+	assert.Len(t, forger.received(), 1)
+}
@@ -0,0 +1,285 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Code is a RADIUS packet type.
+type Code byte
+
+// Packet codes used by the client, see RFC 2865 and RFC 2866.
+const (
+	AccessRequest      = Code(1)
+	AccessAccept       = Code(2)
+	AccessReject       = Code(3)
+	AccountingRequest  = Code(4)
+	AccountingResponse = Code(5)
+	AccessChallenge    = Code(11)
+)
+
+// Type is a RADIUS attribute type.
+type Type byte
+
+// Attribute types used by the client.
+const (
+	UserName             = Type(1)
+	UserPassword         = Type(2)
+	NASIPAddress         = Type(4)
+	NASPort              = Type(5)
+	ServiceType          = Type(6)
+	FramedIPAddress      = Type(8)
+	ReplyMessage         = Type(18)
+	Class                = Type(25)
+	CallingStationID     = Type(31)
+	NASIdentifier        = Type(32)
+	AcctStatusType       = Type(40)
+	AcctInputOctets      = Type(42)
+	AcctOutputOctets     = Type(43)
+	AcctSessionID        = Type(44)
+	AcctSessionTime      = Type(46)
+	AcctInputGigawords   = Type(52)
+	AcctOutputGigawords  = Type(53)
+	EventTimestamp       = Type(55)
+	NASPortType          = Type(61)
+	MessageAuthenticator = Type(80)
+)
+
+const (
+	headerSize        = 20
+	maxPacketSize     = 4096
+	maxAttributeSize  = 253
+	maxPasswordSize   = 128
+	authenticatorSize = 16
+)
+
+// Attribute is a single RADIUS attribute.
+type Attribute struct {
+	Type  Type
+	Value []byte
+}
+
+// Packet is a RADIUS packet.
+type Packet struct {
+	Code          Code
+	Identifier    byte
+	Authenticator [authenticatorSize]byte
+	Attributes    []Attribute
+}
+
+// Add appends the attribute.
+func (p *Packet) Add(t Type, value []byte) {
+	p.Attributes = append(p.Attributes, Attribute{Type: t, Value: value})
+}
+
+// AddString appends the text attribute.
+func (p *Packet) AddString(t Type, value string) {
+	p.Add(t, []byte(value))
+}
+
+// AddUint32 appends the integer attribute.
+func (p *Packet) AddUint32(t Type, value uint32) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, value)
+	p.Add(t, b)
+}
+
+// AddIP appends the IPv4 address attribute, other addresses are skipped.
+func (p *Packet) AddIP(t Type, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		p.Add(t, []byte(ip4))
+	}
+}
+
+// Get returns value of the first attribute of given type.
+func (p *Packet) Get(t Type) ([]byte, bool) {
+	for _, attribute := range p.Attributes {
+		if attribute.Type == t {
+			return attribute.Value, true
+		}
+	}
+	return nil, false
+}
+
+// GetString returns text value of the first attribute of given type.
+func (p *Packet) GetString(t Type) string {
+	value, _ := p.Get(t)
+	return string(value)
+}
+
+// GetUint32 returns integer value of the first attribute of given type.
+func (p *Packet) GetUint32(t Type) (uint32, bool) {
+	value, ok := p.Get(t)
+	if !ok || len(value) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value), true
+}
+
+// Encode serializes the packet as is, authenticators are not calculated.
+func (p *Packet) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write([]byte{byte(p.Code), p.Identifier, 0, 0})
+	buf.Write(p.Authenticator[:])
+	for _, attribute := range p.Attributes {
+		if len(attribute.Value) > maxAttributeSize {
+			return nil, fmt.Errorf("attribute %d is too long", attribute.Type)
+		}
+		buf.WriteByte(byte(attribute.Type))
+		buf.WriteByte(byte(len(attribute.Value) + 2))
+		buf.Write(attribute.Value)
+	}
+	if buf.Len() > maxPacketSize {
+		return nil, errors.New("packet is too long")
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b, nil
+}
+
+// Decode parses the packet.
+func Decode(b []byte) (*Packet, error) {
+	if len(b) < headerSize {
+		return nil, errors.New("packet is too short")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < headerSize || length > len(b) || length > maxPacketSize {
+		return nil, errors.New("invalid packet length")
+	}
+
+	p := &Packet{Code: Code(b[0]), Identifier: b[1]}
+	copy(p.Authenticator[:], b[4:headerSize])
+	for rest := b[headerSize:length]; len(rest) > 0; {
+		if len(rest) < 2 || int(rest[1]) < 2 || int(rest[1]) > len(rest) {
+			return nil, errors.New("invalid attribute length")
+		}
+		size := int(rest[1])
+		p.Add(Type(rest[0]), append([]byte(nil), rest[2:size]...))
+		rest = rest[size:]
+	}
+	return p, nil
+}
+
+// EncodePassword hides User-Password value as defined by RFC 2865 section 5.2.
+func EncodePassword(password, secret []byte, authenticator [authenticatorSize]byte) ([]byte, error) {
+	if len(password) > maxPasswordSize {
+		return nil, errors.New("password is too long")
+	}
+	size := (len(password) + 15) / 16 * 16
+	if size == 0 {
+		size = 16
+	}
+	result := make([]byte, size)
+	copy(result, password)
+
+	previous := authenticator[:]
+	for i := 0; i < size; i += 16 {
+		hash := md5.New()
+		hash.Write(secret)
+		hash.Write(previous)
+		digest := hash.Sum(nil)
+		for j := 0; j < 16; j++ {
+			result[i+j] ^= digest[j]
+		}
+		previous = result[i : i+16]
+	}
+	return result, nil
+}
+
+// DecodePassword reveals User-Password value hidden by EncodePassword.
+func DecodePassword(value, secret []byte, authenticator [authenticatorSize]byte) ([]byte, error) {
+	if len(value) == 0 || len(value)%16 != 0 {
+		return nil, errors.New("invalid password length")
+	}
+	result := make([]byte, len(value))
+	previous := authenticator[:]
+	for i := 0; i < len(value); i += 16 {
+		hash := md5.New()
+		hash.Write(secret)
+		hash.Write(previous)
+		digest := hash.Sum(nil)
+		for j := 0; j < 16; j++ {
+			result[i+j] = value[i+j] ^ digest[j]
+		}
+		previous = value[i : i+16]
+	}
+	return bytes.TrimRight(result, "\x00"), nil
+}
+
+// ResponseAuthenticator calculates authenticator of response to the request with given authenticator,
+// it is also used for Accounting-Request packets with zeroed request authenticator (RFC 2866 section 3).
+func ResponseAuthenticator(encoded []byte, requestAuthenticator [authenticatorSize]byte, secret []byte) [authenticatorSize]byte {
+	hash := md5.New()
+	hash.Write(encoded[:4])
+	hash.Write(requestAuthenticator[:])
+	hash.Write(encoded[headerSize:])
+	hash.Write(secret)
+	var sum [authenticatorSize]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum
+}
+
+// signMessage fills zeroed Message-Authenticator attribute value of the encoded packet (RFC 3579 section 3.2),
+// authenticator is the one placed in the packet header while signing.
+func signMessage(encoded []byte, authenticator [authenticatorSize]byte, secret []byte) bool {
+	offset, ok := messageAuthenticatorOffset(encoded)
+	if !ok {
+		return false
+	}
+	copy(encoded[offset:offset+authenticatorSize], make([]byte, authenticatorSize))
+	mac := hmac.New(md5.New, secret)
+	mac.Write(encoded[:4])
+	mac.Write(authenticator[:])
+	mac.Write(encoded[headerSize:])
+	copy(encoded[offset:], mac.Sum(nil))
+	return true
+}
+
+// verifyMessage checks Message-Authenticator attribute of the encoded packet,
+// packets without it pass unless the attribute is required.
+func verifyMessage(encoded []byte, authenticator [authenticatorSize]byte, secret []byte, required bool) bool {
+	offset, ok := messageAuthenticatorOffset(encoded)
+	if !ok {
+		return !required
+	}
+	received := append([]byte(nil), encoded[offset:offset+authenticatorSize]...)
+	signed := append([]byte(nil), encoded...)
+	signMessage(signed, authenticator, secret)
+	return hmac.Equal(received, signed[offset:offset+authenticatorSize])
+}
+
+func messageAuthenticatorOffset(encoded []byte) (int, bool) {
+	for i := headerSize; i+2 <= len(encoded); i += int(encoded[i+1]) {
+		if encoded[i+1] < 2 {
+			return 0, false
+		}
+		if Type(encoded[i]) == MessageAuthenticator && int(encoded[i+1]) == authenticatorSize+2 && i+2+authenticatorSize <= len(encoded) {
+			return i + 2, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,75 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package radius
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketEncodeDecode(t *testing.T) {
+	p := &Packet{Code: AccessRequest, Identifier: 7}
+	p.AddString(UserName, "alice")
+	p.AddUint32(NASPort, 3)
+	p.AddIP(FramedIPAddress, net.ParseIP("10.8.0.6"))
+	p.AddIP(FramedIPAddress, net.ParseIP("fd00::1"))
+
+	encoded, err := p.Encode()
+	require.NoError(t, err)
+	assert.Len(t, encoded, 20+7+6+6)
+
+	decoded, err := Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, p, decoded)
+	assert.Equal(t, "alice", decoded.GetString(UserName))
+	port, ok := decoded.GetUint32(NASPort)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(3), port)
+
+	_, err = Decode(encoded[:25])
+	assert.Error(t, err)
+}
+
+// RFC 2865 section 7.1 example.
+func TestPasswordHiding(t *testing.T) {
+	var authenticator [16]byte
+	raw, _ := hex.DecodeString("0f403f9473978057bd83d5cb98f4227a")
+	copy(authenticator[:], raw)
+	secret := []byte("xyzzy5461")
+
+	hidden, err := EncodePassword([]byte("arctangent"), secret, authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "0dbe708d93d413ce3196e43f782a0aee", hex.EncodeToString(hidden))
+
+	password, err := DecodePassword(hidden, secret, authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "arctangent", string(password))
+
+	long, err := EncodePassword([]byte("a password longer than sixteen bytes"), secret, authenticator)
+	require.NoError(t, err)
+	assert.Len(t, long, 48)
+	password, err = DecodePassword(long, secret, authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "a password longer than sixteen bytes", string(password))
+}
@@ -0,0 +1,78 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package radius
+
+import (
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+const (
+	serviceTypeFramed  = 2
+	nasPortTypeVirtual = 5
+)
+
+// Validator checks credentials with RADIUS Access-Request using PAP.
+// Access-Challenge is not supported and is treated as a reject.
+type Validator struct {
+	client *Client
+}
+
+// NewValidator creates RADIUS credentials validator.
+func NewValidator(client *Client) *Validator {
+	return &Validator{client: client}
+}
+
+// Validate checks given credentials, it conforms to credentials.Validator callback.
+func (v *Validator) Validate(clientID int, username, password string) (bool, error) {
+	return v.ValidateInfo(clientID, server.ClientInfo{Username: username}, password)
+}
+
+// ValidateInfo checks given credentials, it conforms to credentials.InfoValidator callback.
+// Client address is sent as Calling-Station-Id.
+func (v *Validator) ValidateInfo(clientID int, info server.ClientInfo, password string) (bool, error) {
+	request := v.client.NewRequest(AccessRequest)
+	request.AddString(UserName, info.Username)
+	request.AddString(UserPassword, password)
+	request.AddUint32(NASPort, uint32(clientID))
+	request.AddUint32(NASPortType, nasPortTypeVirtual)
+	request.AddUint32(ServiceType, serviceTypeFramed)
+	if info.RemoteIP != nil {
+		request.AddString(CallingStationID, info.RemoteIP.String())
+	}
+
+	response, err := v.client.Exchange(request)
+	if err != nil {
+		return false, err
+	}
+
+	switch response.Code {
+	case AccessAccept:
+		return true, nil
+	case AccessChallenge:
+		log.Warn("RADIUS challenge is not supported, rejecting user:", info.Username)
+		return false, nil
+	default:
+		if message := response.GetString(ReplyMessage); message != "" {
+			log.Info("RADIUS rejected user:", info.Username, message)
+		}
+		return false, nil
+	}
+}
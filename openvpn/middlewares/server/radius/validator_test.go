@@ -0,0 +1,65 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package radius
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+func TestValidatorAuthenticatesWithPAP(t *testing.T) {
+	s := newStandIn(t, "secret", acceptAlice)
+	defer s.close()
+	validator := NewValidator(NewClient(Config{
+		Servers:       []Server{s.server()},
+		Timeout:       time.Second,
+		NASIdentifier: "vpn1",
+	}))
+
+	valid, err := validator.Validate(3, "alice", "secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	valid, err = validator.ValidateInfo(4, server.ClientInfo{Username: "alice", RemoteIP: net.ParseIP("192.0.2.1")}, "wrong")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	requests := s.received()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "vpn1", requests[0].GetString(NASIdentifier))
+	port, _ := requests[0].GetUint32(NASPort)
+	assert.Equal(t, uint32(3), port)
+	assert.Equal(t, "192.0.2.1", requests[1].GetString(CallingStationID))
+}
+
+func TestValidatorFailsWithoutServers(t *testing.T) {
+	s := newStandIn(t, "secret", func(*Packet, string) *Packet { return nil })
+	defer s.close()
+	validator := NewValidator(NewClient(Config{Servers: []Server{s.server()}, Timeout: 20 * time.Millisecond}))
+
+	valid, err := validator.Validate(1, "alice", "secret")
+	assert.Error(t, err)
+	assert.False(t, valid)
+}
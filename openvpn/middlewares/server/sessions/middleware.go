@@ -0,0 +1,274 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sessions
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/policy"
+)
+
+const replacedMessage = "session replaced by a newer connection"
+
+// Mode defines how a session over the limit is handled.
+type Mode string
+
+const (
+	// DenyNew denies the connecting client.
+	DenyNew = Mode("deny_new")
+	// KillOldest disconnects the oldest sessions, so that the connecting client fits into the limit.
+	KillOldest = Mode("kill_oldest")
+)
+
+// Config defines session limits, zero limit means unlimited.
+type Config struct {
+	Mode             Mode
+	MaxPerUser       int
+	MaxPerCommonName int
+}
+
+// Middleware limits number of concurrent sessions per username and common name.
+//
+// Sessions are tracked by client events, so it has to be registered as a middleware.
+// Check should be passed to the credentials middleware, so that limits are enforced on CONNECT and REAUTH.
+// In KillOldest mode the oldest sessions are killed only once the new client is ESTABLISHED,
+// so that failed logins do not disconnect anyone.
+// When a limit is reached, tracked sessions are reconciled with the server client list first,
+// so sessions with missed DISCONNECT events do not count. Reconciliation needs a command writer
+// which implements management.OutputCommandWriter, without one only DISCONNECT events end sessions.
+type Middleware struct {
+	*auth.Middleware
+
+	config   Config
+	sessions *policy.Sessions
+	now      func() time.Time
+
+	commandWriter management.CommandWriter
+	// mu serializes checks, so that concurrent clients of the same user see each other's kills.
+	mu sync.Mutex
+	// replaced lists sessions to kill once the client is established, by client ID.
+	replaced map[int][]int
+}
+
+// NewMiddleware creates new instance of Middleware.
+func NewMiddleware(config Config) *Middleware {
+	m := &Middleware{
+		config:   config,
+		sessions: policy.NewSessions(),
+		now:      time.Now,
+		replaced: make(map[int][]int),
+	}
+	m.Middleware = auth.NewMiddleware(m.handleClientEvent)
+	return m
+}
+
+// Start starts the middleware.
+func (m *Middleware) Start(commandWriter management.CommandWriter) error {
+	m.commandWriter = commandWriter
+	return m.Middleware.Start(commandWriter)
+}
+
+// Sessions returns sessions tracked by the middleware.
+func (m *Middleware) Sessions() *policy.Sessions {
+	return m.sessions
+}
+
+// Check enforces session limits, it conforms to credentials.Check callback.
+func (m *Middleware) Check(event server.ClientEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.replaced, event.ClientID)
+	info := event.Info()
+	violation := m.violation(event.ClientID, info)
+	if violation == nil {
+		return nil
+	}
+	if m.reconcile() {
+		violation = m.violation(event.ClientID, info)
+		if violation == nil {
+			return nil
+		}
+	}
+
+	if m.config.Mode != KillOldest {
+		log.Info("Session limit denied client with ID:", event.ClientID, "because", violation.message)
+		return errors.New(violation.message)
+	}
+
+	for _, session := range violation.oldest {
+		m.replaced[event.ClientID] = append(m.replaced[event.ClientID], session.ClientID)
+	}
+	return nil
+}
+
+type violation struct {
+	message string
+	// oldest are sessions which have to be killed to fit the new one, oldest first.
+	oldest []policy.Session
+}
+
+func (m *Middleware) violation(clientID int, info server.ClientInfo) *violation {
+	var result *violation
+	check := func(limit int, sessions []policy.Session, message string) {
+		others := sessions[:0]
+		for _, session := range sessions {
+			if session.ClientID != clientID {
+				others = append(others, session)
+			}
+		}
+		if limit <= 0 || len(others) < limit {
+			return
+		}
+		if result == nil {
+			result = &violation{message: message}
+		}
+		for _, session := range others[:len(others)-limit+1] {
+			if !containsSession(result.oldest, session.ClientID) {
+				result.oldest = append(result.oldest, session)
+			}
+		}
+	}
+
+	if info.Username != "" {
+		check(m.config.MaxPerUser, m.sessions.ByUsername(info.Username),
+			fmt.Sprintf("maximum number of sessions (%d) reached for user %s", m.config.MaxPerUser, info.Username))
+	}
+	if info.CommonName != "" {
+		check(m.config.MaxPerCommonName, m.sessions.ByCommonName(info.CommonName),
+			fmt.Sprintf("maximum number of sessions (%d) reached for common name %s", m.config.MaxPerCommonName, info.CommonName))
+	}
+	return result
+}
+
+// reconcile removes tracked sessions which are no longer connected, it tells whether any was removed.
+func (m *Middleware) reconcile() bool {
+	// status output has no SUCCESS line, it can not be read as MultiLineCommand
+	writer, ok := m.commandWriter.(management.OutputCommandWriter)
+	if !ok {
+		return false
+	}
+	lines, err := writer.OutputCommand("status 3")
+	if err != nil {
+		log.Error("Unable to get client list:", err)
+		return false
+	}
+	connected, err := parseClientIDs(lines)
+	if err != nil {
+		log.Error("Unable to parse client list:", err)
+		return false
+	}
+
+	removed := false
+	for _, session := range m.sessions.All() {
+		if !connected[session.ClientID] {
+			log.Warn("Removing stale session of client with ID:", session.ClientID)
+			m.sessions.Remove(session.ClientID)
+			removed = true
+		}
+	}
+	return removed
+}
+
+func (m *Middleware) handleClientEvent(event server.ClientEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch event.EventType {
+	case server.Reauth:
+		// username may change on renegotiation, the session keeps its age
+		if session, ok := m.sessions.Get(event.ClientID); ok {
+			session.Username = event.Env["username"]
+			m.sessions.Add(session)
+		}
+	case server.Established:
+		m.sessions.HandleClientEvent(event, m.now())
+		m.replace(event.ClientID)
+	case server.Disconnect:
+		delete(m.replaced, event.ClientID)
+		m.sessions.HandleClientEvent(event, m.now())
+	default:
+		// CONNECT may be handled after Check, so it must not forget the sessions to replace
+		m.sessions.HandleClientEvent(event, m.now())
+	}
+}
+
+// replace kills sessions replaced by the established client, it must be called with m.mu held.
+func (m *Middleware) replace(clientID int) {
+	replaced := m.replaced[clientID]
+	delete(m.replaced, clientID)
+
+	for _, replacedID := range replaced {
+		if _, ok := m.sessions.Get(replacedID); !ok {
+			continue
+		}
+		log.Info("Session limit kills client with ID:", replacedID, "replaced by client with ID:", clientID)
+		if _, err := m.ClientKillWithReason(replacedID, auth.KillHalt, replacedMessage); err != nil {
+			log.Error("Unable to kill client:", err)
+			continue
+		}
+		m.sessions.Remove(replacedID)
+	}
+}
+
+// parseClientIDs returns client IDs listed in "status 3" output.
+func parseClientIDs(lines []string) (map[int]bool, error) {
+	column := -1
+	clientIDs := make(map[int]bool)
+	for _, line := range lines {
+		fields := strings.Split(line, "\t")
+		switch {
+		case len(fields) > 1 && fields[0] == "HEADER" && fields[1] == "CLIENT_LIST":
+			for i, name := range fields[1:] {
+				if name == "Client ID" {
+					column = i
+				}
+			}
+		case fields[0] == "CLIENT_LIST":
+			if column < 0 || column >= len(fields) {
+				return nil, errors.New("client list has no client ID column")
+			}
+			clientID, err := strconv.Atoi(fields[column])
+			if err != nil {
+				return nil, fmt.Errorf("invalid client ID %q", fields[column])
+			}
+			clientIDs[clientID] = true
+		}
+	}
+	return clientIDs, nil
+}
+
+func containsSession(sessions []policy.Session, clientID int) bool {
+	for _, session := range sessions {
+		if session.ClientID == clientID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sessions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+const statusHeader = "HEADER\tCLIENT_LIST\tCommon Name\tReal Address\tVirtual Address\tVirtual IPv6 Address\tBytes Received\tBytes Sent\tConnected Since\tConnected Since (time_t)\tUsername\tClient ID\tPeer ID"
+
+func statusLine(clientID int) string {
+	return fmt.Sprintf("CLIENT_LIST\tcn\t1.2.3.4:1194\t10.8.0.6\t\t100\t200\tMon Jun  1 12:00:00 2020\t1591012800\talice\t%d\t0", clientID)
+}
+
+func newMiddleware(t *testing.T, config Config) (*Middleware, *management.MockConnection) {
+	connection := &management.MockConnection{}
+	m := NewMiddleware(config)
+	clock := time.Unix(1591012800, 0)
+	m.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	require.NoError(t, m.Start(connection))
+	return m, connection
+}
+
+func establish(t *testing.T, m *Middleware, clientID int, username, commonName string) {
+	for _, line := range []string{
+		fmt.Sprintf(">CLIENT:ESTABLISHED,%d", clientID),
+		">CLIENT:ENV,username=" + username,
+		">CLIENT:ENV,common_name=" + commonName,
+		">CLIENT:ENV,END",
+	} {
+		_, err := m.ConsumeLine(line)
+		require.NoError(t, err)
+	}
+}
+
+func disconnect(t *testing.T, m *Middleware, clientID int) {
+	for _, line := range []string{fmt.Sprintf(">CLIENT:DISCONNECT,%d", clientID), ">CLIENT:ENV,END"} {
+		_, err := m.ConsumeLine(line)
+		require.NoError(t, err)
+	}
+}
+
+func connectEvent(eventType server.ClientEventType, clientID int, username, commonName string) server.ClientEvent {
+	return server.ClientEvent{
+		EventType: eventType,
+		ClientID:  clientID,
+		ClientKey: 1,
+		Env:       map[string]string{"username": username, "common_name": commonName},
+	}
+}
+
+func clientIDs(m *Middleware) []int {
+	var ids []int
+	for _, session := range m.Sessions().All() {
+		ids = append(ids, session.ClientID)
+	}
+	return ids
+}
+
+func Test_DenyNew(t *testing.T) {
+	m, connection := newMiddleware(t, Config{Mode: DenyNew, MaxPerUser: 2})
+	connection.MultilineResponse = []string{statusHeader, statusLine(1), statusLine(2)}
+
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 1, "alice", "laptop")))
+	establish(t, m, 1, "alice", "laptop")
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 2, "alice", "phone")))
+	establish(t, m, 2, "alice", "phone")
+	assert.Empty(t, connection.WrittenLines)
+
+	assert.EqualError(t, m.Check(connectEvent(server.Connect, 3, "alice", "tablet")), "maximum number of sessions (2) reached for user alice")
+	assert.Equal(t, []string{"status 3"}, connection.WrittenLines)
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 3, "bob", "tablet")))
+
+	disconnect(t, m, 1)
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 3, "alice", "tablet")))
+}
+
+func Test_KillOldest(t *testing.T) {
+	m, connection := newMiddleware(t, Config{Mode: KillOldest, MaxPerUser: 2})
+	connection.MultilineResponse = []string{statusHeader, statusLine(1), statusLine(2)}
+	establish(t, m, 1, "alice", "laptop")
+	establish(t, m, 2, "alice", "phone")
+
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 3, "alice", "tablet")))
+	assert.Equal(t, []string{"status 3"}, connection.WrittenLines)
+	assert.Equal(t, []int{1, 2}, clientIDs(m))
+
+	establish(t, m, 3, "alice", "tablet")
+	assert.Equal(t, []string{"status 3", `client-kill 1 "HALT,session replaced by a newer connection"`}, connection.WrittenLines)
+	assert.Equal(t, []int{2, 3}, clientIDs(m))
+}
+
+func Test_KillOldestWaitsForAuthentication(t *testing.T) {
+	m, connection := newMiddleware(t, Config{Mode: KillOldest, MaxPerUser: 1})
+	connection.MultilineResponse = []string{statusHeader, statusLine(1)}
+	establish(t, m, 1, "alice", "laptop")
+
+	// credentials of the client are rejected after the check passed
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 2, "alice", "phone")))
+	disconnect(t, m, 2)
+
+	establish(t, m, 3, "alice", "tablet")
+	assert.Equal(t, []string{"status 3"}, connection.WrittenLines)
+	assert.Equal(t, []int{1, 3}, clientIDs(m))
+}
+
+func Test_KillOldestSurvivesEventsBeforeEstablished(t *testing.T) {
+	m, connection := newMiddleware(t, Config{Mode: KillOldest, MaxPerUser: 1})
+	connection.MultilineResponse = []string{statusHeader, statusLine(1)}
+	establish(t, m, 1, "alice", "laptop")
+
+	// the middleware is registered after the credentials middleware, which has run the check already
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 2, "alice", "phone")))
+	for _, line := range []string{
+		">CLIENT:CONNECT,2,1",
+		">CLIENT:ENV,username=alice",
+		">CLIENT:ENV,common_name=phone",
+		">CLIENT:ENV,END",
+		">CLIENT:ADDRESS,2,10.8.0.10,1",
+	} {
+		_, err := m.ConsumeLine(line)
+		require.NoError(t, err)
+	}
+
+	establish(t, m, 2, "alice", "phone")
+	assert.Equal(t, []string{"status 3", `client-kill 1 "HALT,session replaced by a newer connection"`}, connection.WrittenLines)
+	assert.Equal(t, []int{2}, clientIDs(m))
+}
+
+func Test_MaxPerCommonName(t *testing.T) {
+	m, connection := newMiddleware(t, Config{Mode: KillOldest, MaxPerUser: 3, MaxPerCommonName: 1})
+	connection.MultilineResponse = []string{statusHeader, statusLine(1), statusLine(2)}
+	establish(t, m, 1, "alice", "laptop")
+	establish(t, m, 2, "bob", "laptop")
+
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 3, "carol", "laptop")))
+	establish(t, m, 3, "carol", "laptop")
+	assert.Equal(t, []string{
+		"status 3",
+		`client-kill 1 "HALT,session replaced by a newer connection"`,
+		`client-kill 2 "HALT,session replaced by a newer connection"`,
+	}, connection.WrittenLines)
+	assert.Equal(t, []int{3}, clientIDs(m))
+
+	m.config.Mode = DenyNew
+	connection.MultilineResponse = []string{statusHeader, statusLine(3)}
+	assert.EqualError(t, m.Check(connectEvent(server.Connect, 4, "dave", "laptop")), "maximum number of sessions (1) reached for common name laptop")
+}
+
+func Test_ReauthDoesNotCountOwnSession(t *testing.T) {
+	m, connection := newMiddleware(t, Config{Mode: DenyNew, MaxPerUser: 1})
+	establish(t, m, 1, "alice", "laptop")
+
+	event := connectEvent(server.Reauth, 1, "alice", "laptop")
+	assert.NoError(t, m.Check(event))
+	_, err := m.ConsumeLine(">CLIENT:REAUTH,1,2")
+	require.NoError(t, err)
+	_, err = m.ConsumeLine(">CLIENT:ENV,username=bob")
+	require.NoError(t, err)
+	_, err = m.ConsumeLine(">CLIENT:ENV,END")
+	require.NoError(t, err)
+
+	session, ok := m.Sessions().Get(1)
+	require.True(t, ok)
+	assert.Equal(t, "bob", session.Username)
+	assert.Equal(t, "laptop", session.CommonName)
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 2, "alice", "phone")))
+	assert.Empty(t, connection.WrittenLines)
+}
+
+func Test_MissedDisconnectIsReconciled(t *testing.T) {
+	m, connection := newMiddleware(t, Config{Mode: DenyNew, MaxPerUser: 1})
+	establish(t, m, 1, "alice", "laptop")
+	establish(t, m, 2, "bob", "laptop")
+
+	connection.MultilineResponse = []string{"TITLE\tOpenVPN 2.4.9", statusHeader, statusLine(2), "GLOBAL_STATS\tMax bcast/mcast queue length\t0"}
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 3, "alice", "phone")))
+	assert.Equal(t, []int{2}, clientIDs(m))
+}
+
+// commandRecorder implements only management.CommandWriter, MultiLineCommand fails as it would on status output.
+type commandRecorder struct {
+	commands []string
+}
+
+func (c *commandRecorder) SingleLineCommand(template string, args ...interface{}) (string, error) {
+	c.commands = append(c.commands, fmt.Sprintf(template, args...))
+	return "", nil
+}
+
+func (c *commandRecorder) MultiLineCommand(template string, args ...interface{}) (string, []string, error) {
+	c.commands = append(c.commands, fmt.Sprintf(template, args...))
+	return "", nil, errors.New("unknown command response: TITLE")
+}
+
+func Test_ReconcileNeedsOutputCommands(t *testing.T) {
+	commands := &commandRecorder{}
+	m := NewMiddleware(Config{Mode: DenyNew, MaxPerUser: 1})
+	require.NoError(t, m.Start(commands))
+	establish(t, m, 1, "alice", "laptop")
+
+	assert.EqualError(t, m.Check(connectEvent(server.Connect, 2, "alice", "phone")), "maximum number of sessions (1) reached for user alice")
+	assert.Empty(t, commands.commands)
+
+	disconnect(t, m, 1)
+	assert.NoError(t, m.Check(connectEvent(server.Connect, 2, "alice", "phone")))
+}
+
+func Test_ParseClientIDs(t *testing.T) {
+	ids, err := parseClientIDs([]string{statusHeader, statusLine(5), statusLine(8)})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]bool{5: true, 8: true}, ids)
+
+	_, err = parseClientIDs([]string{statusLine(5)})
+	assert.Error(t, err)
+
+	_, err = parseClientIDs([]string{statusHeader, strings.Replace(statusLine(5), "\t5\t", "\tx\t", 1)})
+	assert.Error(t, err)
+}
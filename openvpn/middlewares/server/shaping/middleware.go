@@ -0,0 +1,220 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package shaping
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+)
+
+const maxSlotID = 0xfffe
+
+// Classifier callback assigns bandwidth class to the authenticating client. Zero class leaves client unshaped.
+type Classifier func(clientID int, info server.ClientInfo) Class
+
+// Middleware enforces bandwidth classes on client virtual IPs.
+//
+// Class is assigned on CONNECT and REAUTH, rules are added once the client is ESTABLISHED
+// and has a virtual IP and removed on DISCONNECT.
+type Middleware struct {
+	*auth.Middleware
+
+	classify  Classifier
+	generator Generator
+	apply     Applier
+
+	mu      sync.Mutex
+	classes map[int]Class
+	// ips keeps virtual IPs of established clients, so that their class may change on REAUTH
+	ips    map[int]net.IP
+	shaped map[int]Slot
+	used   map[int]bool
+}
+
+// NewMiddleware creates new instance of Middleware, rules rendered by generator are run by apply.
+func NewMiddleware(classify Classifier, generator Generator, apply Applier) *Middleware {
+	m := &Middleware{
+		classify:  classify,
+		generator: generator,
+		apply:     apply,
+		classes:   make(map[int]Class),
+		ips:       make(map[int]net.IP),
+		shaped:    make(map[int]Slot),
+		used:      make(map[int]bool),
+	}
+	m.Middleware = auth.NewMiddleware(m.handleClientEvent)
+	return m
+}
+
+// Start prepares shaping rules and starts the middleware.
+func (m *Middleware) Start(commandWriter management.CommandWriter) error {
+	if err := m.apply(m.generator.Setup()); err != nil {
+		return fmt.Errorf("unable to set up bandwidth shaping: %w", err)
+	}
+	return m.Middleware.Start(commandWriter)
+}
+
+// Stop removes all shaping rules and stops the middleware.
+func (m *Middleware) Stop(commandWriter management.CommandWriter) error {
+	m.mu.Lock()
+	clientIDs := make([]int, 0, len(m.shaped))
+	for clientID := range m.shaped {
+		clientIDs = append(clientIDs, clientID)
+	}
+	m.classes = make(map[int]Class)
+	m.ips = make(map[int]net.IP)
+	m.mu.Unlock()
+
+	for _, clientID := range clientIDs {
+		m.unshape(clientID)
+	}
+	if err := m.apply(m.generator.Teardown()); err != nil {
+		log.Error("Unable to tear down bandwidth shaping:", err)
+	}
+	return m.Middleware.Stop(commandWriter)
+}
+
+// Shaped returns slots of currently shaped clients.
+func (m *Middleware) Shaped() []Slot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	slots := make([]Slot, 0, len(m.shaped))
+	for _, slot := range m.shaped {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+// handleClientEvent keeps m.mu only while reading and updating the state, rules are applied without it.
+func (m *Middleware) handleClientEvent(event server.ClientEvent) {
+	switch event.EventType {
+	case server.Connect, server.Reauth:
+		class := m.classify(event.ClientID, event.Info())
+		m.mu.Lock()
+		m.classes[event.ClientID] = class
+		slot, shaped := m.shaped[event.ClientID]
+		ip, established := m.ips[event.ClientID]
+		m.mu.Unlock()
+
+		switch {
+		case shaped && slot.Class != class:
+			m.unshape(event.ClientID)
+			m.shape(event.ClientID, slot.IP, class)
+		case !shaped && established:
+			// i.e. the client was unlimited before
+			m.shape(event.ClientID, ip, class)
+		}
+	case server.Established:
+		ip := virtualIP(event.Env)
+		m.mu.Lock()
+		class, ok := m.classes[event.ClientID]
+		if ip != nil {
+			m.ips[event.ClientID] = ip
+		}
+		m.mu.Unlock()
+		if !ok {
+			class = m.classify(event.ClientID, event.Info())
+			m.mu.Lock()
+			m.classes[event.ClientID] = class
+			m.mu.Unlock()
+		}
+		m.shape(event.ClientID, ip, class)
+	case server.Disconnect:
+		m.unshape(event.ClientID)
+		m.mu.Lock()
+		delete(m.classes, event.ClientID)
+		delete(m.ips, event.ClientID)
+		m.mu.Unlock()
+	}
+}
+
+func (m *Middleware) shape(clientID int, ip net.IP, class Class) {
+	if ip == nil || class.Unlimited() {
+		return
+	}
+
+	m.mu.Lock()
+	if _, ok := m.shaped[clientID]; ok {
+		m.mu.Unlock()
+		return
+	}
+	id := m.allocate()
+	m.mu.Unlock()
+	if id == 0 {
+		log.Error("Unable to shape client, no free slots left:", clientID)
+		return
+	}
+	slot := Slot{ID: id, ClientID: clientID, IP: ip, Class: class}
+
+	log.Info("Shaping client with ID:", clientID, "class:", class.Name)
+	err := m.apply(m.generator.Add(slot))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		log.Error("Unable to apply bandwidth rules of client:", clientID, err)
+		delete(m.used, id)
+		return
+	}
+	m.shaped[clientID] = slot
+}
+
+// unshape frees the slot only once its rules are removed, so that leftover rules never clash with a new client.
+func (m *Middleware) unshape(clientID int) {
+	m.mu.Lock()
+	slot, ok := m.shaped[clientID]
+	delete(m.shaped, clientID)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := m.apply(m.generator.Delete(slot)); err != nil {
+		log.Error("Unable to remove bandwidth rules of client:", clientID, err)
+		return
+	}
+	m.mu.Lock()
+	delete(m.used, slot.ID)
+	m.mu.Unlock()
+}
+
+func (m *Middleware) allocate() int {
+	for id := 1; id <= maxSlotID; id++ {
+		if !m.used[id] {
+			m.used[id] = true
+			return id
+		}
+	}
+	return 0
+}
+
+func virtualIP(env map[string]string) net.IP {
+	if ip := net.ParseIP(env["ifconfig_pool_remote_ip"]); ip != nil {
+		return ip
+	}
+	return net.ParseIP(env["ifconfig_pool_remote_ip6"])
+}
@@ -0,0 +1,225 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package shaping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+type recordingApplier struct {
+	commands [][]string
+	err      error
+	// during is run on every apply, i.e. to inspect the middleware while rules are applied
+	during func()
+}
+
+func (r *recordingApplier) apply(commands [][]string) error {
+	if r.during != nil {
+		r.during()
+	}
+	r.commands = append(r.commands, commands...)
+	return r.err
+}
+
+func (r *recordingApplier) take() [][]string {
+	commands := r.commands
+	r.commands = nil
+	return commands
+}
+
+func classifyByUsername(_ int, info server.ClientInfo) Class {
+	switch info.Username {
+	case "gold":
+		return gold
+	case "silver":
+		return Class{Name: "silver", Download: 1000000}
+	}
+	return Class{}
+}
+
+func consume(t *testing.T, m *Middleware, lines ...string) {
+	for _, line := range lines {
+		_, err := m.ConsumeLine(line)
+		require.NoError(t, err, line)
+	}
+}
+
+func connect(t *testing.T, m *Middleware, event string, clientID int, username string) {
+	consume(t, m,
+		fmt.Sprintf(">CLIENT:%s,%d,1", event, clientID),
+		">CLIENT:ENV,username="+username,
+		">CLIENT:ENV,END",
+	)
+}
+
+func establish(t *testing.T, m *Middleware, clientID int, ip string) {
+	consume(t, m,
+		fmt.Sprintf(">CLIENT:ESTABLISHED,%d", clientID),
+		">CLIENT:ENV,ifconfig_pool_remote_ip="+ip,
+		">CLIENT:ENV,END",
+	)
+}
+
+func disconnect(t *testing.T, m *Middleware, clientID int) {
+	consume(t, m, fmt.Sprintf(">CLIENT:DISCONNECT,%d", clientID), ">CLIENT:ENV,END")
+}
+
+func newMiddleware(t *testing.T) (*Middleware, *recordingApplier) {
+	applier := &recordingApplier{}
+	m := NewMiddleware(classifyByUsername, TC{Device: "tun0"}, applier.apply)
+	require.NoError(t, m.Start(&management.MockConnection{}))
+	assert.Equal(t, TC{Device: "tun0"}.Setup(), applier.take())
+	return m, applier
+}
+
+func Test_ShapesEstablishedClients(t *testing.T) {
+	m, applier := newMiddleware(t)
+	tc := TC{Device: "tun0"}
+
+	connect(t, m, "CONNECT", 3, "gold")
+	assert.Empty(t, applier.take())
+
+	establish(t, m, 3, "10.8.0.6")
+	first := Slot{ID: 1, ClientID: 3, IP: net.ParseIP("10.8.0.6"), Class: gold}
+	assert.Equal(t, tc.Add(first), applier.take())
+
+	connect(t, m, "CONNECT", 4, "bronze")
+	establish(t, m, 4, "10.8.0.10")
+	assert.Empty(t, applier.take())
+
+	connect(t, m, "CONNECT", 5, "silver")
+	establish(t, m, 5, "10.8.0.14")
+	silver := Slot{ID: 2, ClientID: 5, IP: net.ParseIP("10.8.0.14"), Class: classifyByUsername(5, server.ClientInfo{Username: "silver"})}
+	assert.Equal(t, tc.Add(silver), applier.take())
+	assert.ElementsMatch(t, []Slot{first, silver}, m.Shaped())
+
+	disconnect(t, m, 3)
+	assert.Equal(t, tc.Delete(first), applier.take())
+	disconnect(t, m, 4)
+	assert.Empty(t, applier.take())
+
+	connect(t, m, "CONNECT", 6, "gold")
+	establish(t, m, 6, "10.8.0.18")
+	reused := Slot{ID: 1, ClientID: 6, IP: net.ParseIP("10.8.0.18"), Class: gold}
+	assert.Equal(t, tc.Add(reused), applier.take())
+
+	require.NoError(t, m.Stop(&management.MockConnection{}))
+	commands := applier.take()
+	assert.Subset(t, commands, tc.Delete(silver))
+	assert.Subset(t, commands, tc.Delete(reused))
+	assert.Equal(t, tc.Teardown(), commands[len(commands)-2:])
+	assert.Empty(t, m.Shaped())
+}
+
+func Test_ReauthChangesClass(t *testing.T) {
+	m, applier := newMiddleware(t)
+	tc := TC{Device: "tun0"}
+
+	connect(t, m, "CONNECT", 3, "gold")
+	establish(t, m, 3, "10.8.0.6")
+	old := Slot{ID: 1, ClientID: 3, IP: net.ParseIP("10.8.0.6"), Class: gold}
+	applier.take()
+
+	connect(t, m, "REAUTH", 3, "gold")
+	assert.Empty(t, applier.take())
+
+	connect(t, m, "REAUTH", 3, "silver")
+	updated := old
+	updated.Class = classifyByUsername(3, server.ClientInfo{Username: "silver"})
+	assert.Equal(t, append(tc.Delete(old), tc.Add(updated)...), applier.take())
+
+	connect(t, m, "REAUTH", 3, "bronze")
+	assert.Equal(t, tc.Delete(updated), applier.take())
+	assert.Empty(t, m.Shaped())
+}
+
+func Test_ReauthShapesClientEstablishedUnlimited(t *testing.T) {
+	m, applier := newMiddleware(t)
+
+	connect(t, m, "CONNECT", 3, "bronze")
+	establish(t, m, 3, "10.8.0.6")
+	assert.Empty(t, applier.take())
+
+	connect(t, m, "REAUTH", 3, "silver")
+	silver := Slot{ID: 1, ClientID: 3, IP: net.ParseIP("10.8.0.6"), Class: classifyByUsername(3, server.ClientInfo{Username: "silver"})}
+	assert.Equal(t, TC{Device: "tun0"}.Add(silver), applier.take())
+	assert.Equal(t, []Slot{silver}, m.Shaped())
+
+	disconnect(t, m, 3)
+	applier.take()
+	connect(t, m, "REAUTH", 3, "silver")
+	assert.Empty(t, applier.take(), "disconnected client is not shaped")
+}
+
+func Test_ClassifiesClientsConnectedBeforeStart(t *testing.T) {
+	m, applier := newMiddleware(t)
+
+	consume(t, m,
+		">CLIENT:ESTABLISHED,3",
+		">CLIENT:ENV,username=gold",
+		">CLIENT:ENV,ifconfig_pool_remote_ip6=fd00::1000",
+		">CLIENT:ENV,END",
+	)
+	assert.Equal(t, TC{Device: "tun0"}.Add(Slot{ID: 1, ClientID: 3, IP: net.ParseIP("fd00::1000"), Class: gold}), applier.take())
+}
+
+func Test_StartFailsWhenSetupFails(t *testing.T) {
+	applier := &recordingApplier{err: errors.New("tc: not found")}
+	m := NewMiddleware(classifyByUsername, TC{Device: "tun0"}, applier.apply)
+
+	assert.EqualError(t, m.Start(&management.MockConnection{}), "unable to set up bandwidth shaping: tc: not found")
+}
+
+func Test_FailedRulesDoNotHoldSlots(t *testing.T) {
+	m, applier := newMiddleware(t)
+	tc := TC{Device: "tun0"}
+	var shapedDuringApply []Slot
+	applier.during = func() { shapedDuringApply = m.Shaped() }
+
+	applier.err = errors.New("tc: exit status 2")
+	connect(t, m, "CONNECT", 3, "gold")
+	establish(t, m, 3, "10.8.0.6")
+	assert.Empty(t, shapedDuringApply, "slot is recorded once rules are applied")
+	assert.Empty(t, m.Shaped())
+	applier.take()
+
+	applier.err = nil
+	connect(t, m, "CONNECT", 4, "gold")
+	establish(t, m, 4, "10.8.0.10")
+	slot := Slot{ID: 1, ClientID: 4, IP: net.ParseIP("10.8.0.10"), Class: gold}
+	assert.Equal(t, tc.Add(slot), applier.take(), "failed slot is free again")
+	assert.Equal(t, []Slot{slot}, m.Shaped())
+
+	// slot of rules which could not be removed is not reused
+	applier.err = errors.New("tc: exit status 2")
+	disconnect(t, m, 4)
+	applier.err = nil
+	connect(t, m, "CONNECT", 5, "gold")
+	establish(t, m, 5, "10.8.0.14")
+	assert.Equal(t, tc.Add(Slot{ID: 2, ClientID: 5, IP: net.ParseIP("10.8.0.14"), Class: gold}), applier.take()[len(tc.Delete(slot)):])
+}
@@ -0,0 +1,241 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package shaping
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Class is a bandwidth class assigned to a client. Rates are in bits per second, zero means unlimited.
+type Class struct {
+	Name     string
+	Download uint64
+	Upload   uint64
+}
+
+// Unlimited tells whether class does not limit any direction.
+func (c Class) Unlimited() bool {
+	return c.Download == 0 && c.Upload == 0
+}
+
+// Slot is a shaped client. ID is unique among shaped clients and is in range 1-0xfffe, so it can be used as a rule handle.
+type Slot struct {
+	ID       int
+	ClientID int
+	IP       net.IP
+	Class    Class
+}
+
+// Generator renders shaping rules as commands, without running them.
+type Generator interface {
+	// Setup returns commands preparing the device or table, it clears previously added rules.
+	Setup() [][]string
+	// Add returns commands enforcing class of the slot.
+	Add(slot Slot) [][]string
+	// Delete returns commands removing rules added for the slot.
+	Delete(slot Slot) [][]string
+	// Teardown returns commands removing everything created by Setup.
+	Teardown() [][]string
+}
+
+// Applier runs generated commands.
+type Applier func(commands [][]string) error
+
+// ExecApplier runs commands as processes. It runs all commands and returns the first error.
+func ExecApplier(commands [][]string) error {
+	var first error
+	for _, command := range commands {
+		output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+		if err != nil && first == nil {
+			first = fmt.Errorf("%s: %w: %s", strings.Join(command, " "), err, strings.TrimSpace(string(output)))
+		}
+	}
+	return first
+}
+
+// TC generates Linux tc rules for the tunnel device.
+// Download is shaped with a HTB class per client, upload is policed on the ingress qdisc.
+type TC struct {
+	Device string
+}
+
+// Setup replaces root and ingress qdiscs of the device.
+func (t TC) Setup() [][]string {
+	return [][]string{
+		{"tc", "qdisc", "replace", "dev", t.Device, "root", "handle", "1:", "htb"},
+		{"tc", "qdisc", "replace", "dev", t.Device, "handle", "ffff:", "ingress"},
+	}
+}
+
+// Add returns HTB class and filters of the slot.
+func (t TC) Add(slot Slot) [][]string {
+	protocol, match, prefix := tcMatch(slot.IP)
+	prio := strconv.Itoa(slot.ID)
+	classID := fmt.Sprintf("1:%x", slot.ID)
+	address := slot.IP.String() + prefix
+
+	var commands [][]string
+	if rate := slot.Class.Download; rate > 0 {
+		commands = append(commands,
+			[]string{"tc", "class", "add", "dev", t.Device, "parent", "1:", "classid", classID, "htb", "rate", bits(rate), "ceil", bits(rate)},
+			[]string{"tc", "filter", "add", "dev", t.Device, "parent", "1:", "protocol", protocol, "prio", prio, "u32", "match", match, "dst", address, "flowid", classID},
+		)
+	}
+	if rate := slot.Class.Upload; rate > 0 {
+		commands = append(commands,
+			[]string{"tc", "filter", "add", "dev", t.Device, "parent", "ffff:", "protocol", protocol, "prio", prio, "u32", "match", match, "src", address, "police", "rate", bits(rate), "burst", strconv.FormatUint(burst(rate), 10), "drop", "flowid", ":1"},
+		)
+	}
+	return commands
+}
+
+// Delete returns commands removing HTB class and filters of the slot.
+func (t TC) Delete(slot Slot) [][]string {
+	protocol, _, _ := tcMatch(slot.IP)
+	prio := strconv.Itoa(slot.ID)
+
+	var commands [][]string
+	if slot.Class.Download > 0 {
+		commands = append(commands,
+			[]string{"tc", "filter", "del", "dev", t.Device, "parent", "1:", "protocol", protocol, "prio", prio},
+			[]string{"tc", "class", "del", "dev", t.Device, "classid", fmt.Sprintf("1:%x", slot.ID)},
+		)
+	}
+	if slot.Class.Upload > 0 {
+		commands = append(commands,
+			[]string{"tc", "filter", "del", "dev", t.Device, "parent", "ffff:", "protocol", protocol, "prio", prio},
+		)
+	}
+	return commands
+}
+
+// Teardown removes root and ingress qdiscs of the device.
+func (t TC) Teardown() [][]string {
+	return [][]string{
+		{"tc", "qdisc", "del", "dev", t.Device, "root"},
+		{"tc", "qdisc", "del", "dev", t.Device, "ingress"},
+	}
+}
+
+// NFT generates nftables rate limit rules.
+// Client addresses are mapped to per client chains dropping traffic over the class rate.
+type NFT struct {
+	Table string
+}
+
+// Setup creates the table with address verdict maps and forward chain looking them up.
+func (n NFT) Setup() [][]string {
+	commands := [][]string{
+		{"nft", "add", "table", "inet", n.Table},
+		{"nft", "flush", "table", "inet", n.Table},
+		{"nft", "add", "chain", "inet", n.Table, "forward", "{ type filter hook forward priority 0 ; policy accept ; }"},
+	}
+	for _, family := range []struct{ suffix, addressType, match string }{
+		{"4", "ipv4_addr", "ip"},
+		{"6", "ipv6_addr", "ip6"},
+	} {
+		commands = append(commands,
+			[]string{"nft", "add", "map", "inet", n.Table, "download" + family.suffix, "{ type " + family.addressType + " : verdict ; }"},
+			[]string{"nft", "add", "map", "inet", n.Table, "upload" + family.suffix, "{ type " + family.addressType + " : verdict ; }"},
+			[]string{"nft", "add", "rule", "inet", n.Table, "forward", family.match, "daddr", "vmap", "@download" + family.suffix},
+			[]string{"nft", "add", "rule", "inet", n.Table, "forward", family.match, "saddr", "vmap", "@upload" + family.suffix},
+		)
+	}
+	return commands
+}
+
+// Add returns limiting chains of the slot and their map elements.
+func (n NFT) Add(slot Slot) [][]string {
+	var commands [][]string
+	for _, direction := range n.directions(slot) {
+		commands = append(commands,
+			[]string{"nft", "add", "chain", "inet", n.Table, direction.chain},
+			[]string{"nft", "add", "rule", "inet", n.Table, direction.chain, "limit", "rate", "over", strconv.FormatUint(direction.rate/8, 10), "bytes/second", "drop"},
+			[]string{"nft", "add", "element", "inet", n.Table, direction.set, "{ " + slot.IP.String() + " : jump " + direction.chain + " }"},
+		)
+	}
+	return commands
+}
+
+// Delete returns commands removing map elements and chains of the slot.
+func (n NFT) Delete(slot Slot) [][]string {
+	var commands [][]string
+	for _, direction := range n.directions(slot) {
+		commands = append(commands,
+			[]string{"nft", "delete", "element", "inet", n.Table, direction.set, "{ " + slot.IP.String() + " }"},
+			[]string{"nft", "flush", "chain", "inet", n.Table, direction.chain},
+			[]string{"nft", "delete", "chain", "inet", n.Table, direction.chain},
+		)
+	}
+	return commands
+}
+
+// Teardown deletes the table.
+func (n NFT) Teardown() [][]string {
+	return [][]string{
+		{"nft", "delete", "table", "inet", n.Table},
+	}
+}
+
+type direction struct {
+	set   string
+	chain string
+	rate  uint64
+}
+
+func (n NFT) directions(slot Slot) []direction {
+	suffix := "6"
+	if slot.IP.To4() != nil {
+		suffix = "4"
+	}
+
+	var directions []direction
+	if rate := slot.Class.Download; rate > 0 {
+		directions = append(directions, direction{"download" + suffix, fmt.Sprintf("client_%d_down", slot.ID), rate})
+	}
+	if rate := slot.Class.Upload; rate > 0 {
+		directions = append(directions, direction{"upload" + suffix, fmt.Sprintf("client_%d_up", slot.ID), rate})
+	}
+	return directions
+}
+
+func tcMatch(ip net.IP) (protocol, match, prefix string) {
+	if ip.To4() != nil {
+		return "ip", "ip", "/32"
+	}
+	return "ipv6", "ip6", "/128"
+}
+
+func bits(rate uint64) string {
+	return strconv.FormatUint(rate, 10) + "bit"
+}
+
+// burst allows 100ms of traffic at the given rate, but at least a few full size packets.
+func burst(rate uint64) uint64 {
+	const minBurst = 4 * 1500
+	if b := rate / 8 / 10; b > minBurst {
+		return b
+	}
+	return minBurst
+}
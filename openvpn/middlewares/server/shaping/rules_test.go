@@ -0,0 +1,99 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package shaping
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	gold = Class{Name: "gold", Download: 20000000, Upload: 8000000}
+	slot = Slot{ID: 26, ClientID: 3, IP: net.ParseIP("10.8.0.6"), Class: gold}
+)
+
+func Test_TCAdd(t *testing.T) {
+	tc := TC{Device: "tun0"}
+
+	assert.Equal(t, [][]string{
+		{"tc", "class", "add", "dev", "tun0", "parent", "1:", "classid", "1:1a", "htb", "rate", "20000000bit", "ceil", "20000000bit"},
+		{"tc", "filter", "add", "dev", "tun0", "parent", "1:", "protocol", "ip", "prio", "26", "u32", "match", "ip", "dst", "10.8.0.6/32", "flowid", "1:1a"},
+		{"tc", "filter", "add", "dev", "tun0", "parent", "ffff:", "protocol", "ip", "prio", "26", "u32", "match", "ip", "src", "10.8.0.6/32", "police", "rate", "8000000bit", "burst", "100000", "drop", "flowid", ":1"},
+	}, tc.Add(slot))
+
+	assert.Equal(t, [][]string{
+		{"tc", "filter", "del", "dev", "tun0", "parent", "1:", "protocol", "ip", "prio", "26"},
+		{"tc", "class", "del", "dev", "tun0", "classid", "1:1a"},
+		{"tc", "filter", "del", "dev", "tun0", "parent", "ffff:", "protocol", "ip", "prio", "26"},
+	}, tc.Delete(slot))
+}
+
+func Test_TCSingleDirectionIPv6(t *testing.T) {
+	tc := TC{Device: "tun0"}
+	upload := Slot{ID: 1, IP: net.ParseIP("fd00::1000"), Class: Class{Upload: 64000}}
+
+	assert.Equal(t, [][]string{
+		{"tc", "filter", "add", "dev", "tun0", "parent", "ffff:", "protocol", "ipv6", "prio", "1", "u32", "match", "ip6", "src", "fd00::1000/128", "police", "rate", "64000bit", "burst", "6000", "drop", "flowid", ":1"},
+	}, tc.Add(upload))
+	assert.Equal(t, [][]string{
+		{"tc", "filter", "del", "dev", "tun0", "parent", "ffff:", "protocol", "ipv6", "prio", "1"},
+	}, tc.Delete(upload))
+}
+
+func Test_NFTAdd(t *testing.T) {
+	nft := NFT{Table: "openvpn"}
+
+	assert.Equal(t, [][]string{
+		{"nft", "add", "chain", "inet", "openvpn", "client_26_down"},
+		{"nft", "add", "rule", "inet", "openvpn", "client_26_down", "limit", "rate", "over", "2500000", "bytes/second", "drop"},
+		{"nft", "add", "element", "inet", "openvpn", "download4", "{ 10.8.0.6 : jump client_26_down }"},
+		{"nft", "add", "chain", "inet", "openvpn", "client_26_up"},
+		{"nft", "add", "rule", "inet", "openvpn", "client_26_up", "limit", "rate", "over", "1000000", "bytes/second", "drop"},
+		{"nft", "add", "element", "inet", "openvpn", "upload4", "{ 10.8.0.6 : jump client_26_up }"},
+	}, nft.Add(slot))
+
+	assert.Equal(t, [][]string{
+		{"nft", "delete", "element", "inet", "openvpn", "download4", "{ 10.8.0.6 }"},
+		{"nft", "flush", "chain", "inet", "openvpn", "client_26_down"},
+		{"nft", "delete", "chain", "inet", "openvpn", "client_26_down"},
+		{"nft", "delete", "element", "inet", "openvpn", "upload4", "{ 10.8.0.6 }"},
+		{"nft", "flush", "chain", "inet", "openvpn", "client_26_up"},
+		{"nft", "delete", "chain", "inet", "openvpn", "client_26_up"},
+	}, nft.Delete(slot))
+}
+
+func Test_NFTSetup(t *testing.T) {
+	nft := NFT{Table: "openvpn"}
+
+	setup := nft.Setup()
+	assert.Len(t, setup, 11)
+	assert.Contains(t, setup, []string{"nft", "add", "map", "inet", "openvpn", "download6", "{ type ipv6_addr : verdict ; }"})
+	assert.Contains(t, setup, []string{"nft", "add", "rule", "inet", "openvpn", "forward", "ip", "saddr", "vmap", "@upload4"})
+	assert.Equal(t, [][]string{{"nft", "delete", "table", "inet", "openvpn"}}, nft.Teardown())
+}
+
+func Test_ExecApplier(t *testing.T) {
+	assert.NoError(t, ExecApplier([][]string{{"true"}}))
+
+	err := ExecApplier([][]string{{"false"}, {"true"}, {"sh", "-c", "exit 2"}})
+	assert.EqualError(t, err, "false: exit status 1: ")
+}
@@ -0,0 +1,243 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package site
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/config"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+)
+
+// Site is a network behind a client, i.e. branch office router.
+type Site struct {
+	Name string
+	// CommonName is the certificate common name of the site client.
+	CommonName string
+	// Subnets are LAN networks behind the client in CIDR notation.
+	Subnets []string
+}
+
+// Status describes a site and its connection.
+type Status struct {
+	Site
+	Online   bool
+	ClientID int
+	Since    time.Time
+}
+
+// StatusCallback is called when site goes online or offline.
+type StatusCallback func(status Status)
+
+type entry struct {
+	site     Site
+	subnets  []*net.IPNet
+	online   bool
+	clientID int
+	since    time.Time
+}
+
+// Registry maps site clients to networks behind them. It provides iroute config lines of accepted
+// site clients, server route options and tracks which sites are online.
+//
+// ClientConfig should be passed to the credentials middleware, so that iroute lines are sent with client-auth.
+type Registry struct {
+	*auth.Middleware
+
+	onStatus StatusCallback
+	now      func() time.Time
+
+	mu    sync.RWMutex
+	sites map[string]*entry
+}
+
+// NewRegistry creates empty site registry, status callback is optional.
+func NewRegistry(onStatus StatusCallback) *Registry {
+	r := &Registry{
+		onStatus: onStatus,
+		now:      time.Now,
+		sites:    make(map[string]*entry),
+	}
+	r.Middleware = auth.NewMiddleware(r.handleClientEvent)
+	return r
+}
+
+// Add registers the site. Subnets must be valid and must not overlap with any other registered subnet.
+func (r *Registry) Add(site Site) error {
+	if site.CommonName == "" {
+		return fmt.Errorf("site %q has no common name", site.Name)
+	}
+	subnets, err := parseSubnets(site.Subnets)
+	if err != nil {
+		return fmt.Errorf("site %q: %w", site.Name, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.sites[site.CommonName]; exists {
+		return fmt.Errorf("site with common name %q is already registered", site.CommonName)
+	}
+	for _, other := range r.sites {
+		for _, subnet := range subnets {
+			for _, otherSubnet := range other.subnets {
+				if overlaps(subnet, otherSubnet) {
+					return fmt.Errorf("site %q subnet %s overlaps with site %q subnet %s", site.Name, subnet, other.site.Name, otherSubnet)
+				}
+			}
+		}
+	}
+	r.sites[site.CommonName] = &entry{site: site, subnets: subnets}
+	return nil
+}
+
+// Remove unregisters the site with given common name.
+func (r *Registry) Remove(commonName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sites, commonName)
+}
+
+// Sites returns status of all registered sites ordered by name.
+func (r *Registry) Sites() []Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statuses := make([]Status, 0, len(r.sites))
+	for _, e := range r.sites {
+		statuses = append(statuses, e.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// ClientConfig returns iroute lines of the site client, it conforms to credentials.ConfigProvider callback.
+func (r *Registry) ClientConfig(_ int, info server.ClientInfo) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.sites[info.CommonName]
+	if !ok {
+		return nil
+	}
+	lines := make([]string, 0, len(e.subnets))
+	for _, subnet := range e.subnets {
+		if subnet.IP.To4() != nil {
+			lines = append(lines, fmt.Sprintf("iroute %s %s", subnet.IP, net.IP(subnet.Mask)))
+		} else {
+			lines = append(lines, fmt.Sprintf("iroute-ipv6 %s", subnet))
+		}
+	}
+	return lines
+}
+
+// ServerRoutes returns route options for all site subnets, which the server needs to route them into the tunnel.
+func (r *Registry) ServerRoutes() [][]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var routes [][]string
+	for _, e := range r.sites {
+		for _, subnet := range e.subnets {
+			if subnet.IP.To4() != nil {
+				routes = append(routes, []string{"route", subnet.IP.String(), net.IP(subnet.Mask).String()})
+			} else {
+				routes = append(routes, []string{"route-ipv6", subnet.String()})
+			}
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return fmt.Sprint(routes[i]) < fmt.Sprint(routes[j])
+	})
+	return routes
+}
+
+// AddServerRoutes adds route options of all site subnets to the server config.
+func (r *Registry) AddServerRoutes(c *config.GenericConfig) {
+	for _, route := range r.ServerRoutes() {
+		c.SetParam(route[0], route[1:]...)
+	}
+}
+
+func (r *Registry) handleClientEvent(event server.ClientEvent) {
+	var changed *Status
+
+	r.mu.Lock()
+	switch event.EventType {
+	case server.Established:
+		if e, ok := r.sites[event.Env["common_name"]]; ok {
+			e.online, e.clientID, e.since = true, event.ClientID, r.now()
+			status := e.status()
+			changed = &status
+		}
+	case server.Disconnect:
+		for _, e := range r.sites {
+			if e.online && e.clientID == event.ClientID {
+				e.online, e.clientID, e.since = false, 0, r.now()
+				status := e.status()
+				changed = &status
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	if changed != nil && r.onStatus != nil {
+		r.onStatus(*changed)
+	}
+}
+
+func (e *entry) status() Status {
+	return Status{Site: e.site, Online: e.online, ClientID: e.clientID, Since: e.since}
+}
+
+func parseSubnets(cidrs []string) ([]*net.IPNet, error) {
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("no subnets")
+	}
+	subnets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		ip, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		if !ip.Equal(subnet.IP) {
+			return nil, fmt.Errorf("subnet %s has host bits set", cidr)
+		}
+		for _, other := range subnets {
+			if overlaps(subnet, other) {
+				return nil, fmt.Errorf("subnet %s overlaps with %s", subnet, other)
+			}
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
@@ -0,0 +1,134 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package site
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trevor403/go-openvpn-static/openvpn/config"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+)
+
+var (
+	officeA = Site{Name: "office-a", CommonName: "router-a", Subnets: []string{"10.1.0.0/24", "fd00:1::/64"}}
+	officeB = Site{Name: "office-b", CommonName: "router-b", Subnets: []string{"10.2.0.0/16"}}
+)
+
+func consume(t *testing.T, r *Registry, lines ...string) {
+	for _, line := range lines {
+		_, err := r.ConsumeLine(line)
+		require.NoError(t, err, line)
+	}
+}
+
+func Test_AddValidatesSubnets(t *testing.T) {
+	r := NewRegistry(nil)
+	require.NoError(t, r.Add(officeA))
+	require.NoError(t, r.Add(officeB))
+
+	tests := map[string]Site{
+		"invalid CIDR":          {Name: "c", CommonName: "router-c", Subnets: []string{"10.3.0.0"}},
+		"host bits set":         {Name: "c", CommonName: "router-c", Subnets: []string{"10.3.0.1/24"}},
+		"no subnets":            {Name: "c", CommonName: "router-c"},
+		"no common name":        {Name: "c", Subnets: []string{"10.3.0.0/24"}},
+		"overlap within site":   {Name: "c", CommonName: "router-c", Subnets: []string{"10.3.0.0/16", "10.3.1.0/24"}},
+		"overlap other site":    {Name: "c", CommonName: "router-c", Subnets: []string{"10.2.5.0/24"}},
+		"overlap larger net":    {Name: "c", CommonName: "router-c", Subnets: []string{"10.0.0.0/8"}},
+		"overlap ipv6":          {Name: "c", CommonName: "router-c", Subnets: []string{"fd00:1::/48"}},
+		"duplicate common name": {Name: "c", CommonName: "router-a", Subnets: []string{"10.3.0.0/24"}},
+	}
+	for name, site := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, r.Add(site))
+		})
+	}
+	assert.Len(t, r.Sites(), 2)
+
+	r.Remove("router-b")
+	assert.NoError(t, r.Add(Site{Name: "c", CommonName: "router-c", Subnets: []string{"10.2.5.0/24"}}))
+}
+
+func Test_ClientConfig(t *testing.T) {
+	r := NewRegistry(nil)
+	require.NoError(t, r.Add(officeA))
+
+	assert.Equal(t,
+		[]string{"iroute 10.1.0.0 255.255.255.0", "iroute-ipv6 fd00:1::/64"},
+		r.ClientConfig(1, server.ClientInfo{CommonName: "router-a"}),
+	)
+	assert.Empty(t, r.ClientConfig(2, server.ClientInfo{CommonName: "laptop"}))
+}
+
+func Test_ServerRoutes(t *testing.T) {
+	r := NewRegistry(nil)
+	require.NoError(t, r.Add(officeA))
+	require.NoError(t, r.Add(officeB))
+
+	assert.Equal(t, [][]string{
+		{"route", "10.1.0.0", "255.255.255.0"},
+		{"route", "10.2.0.0", "255.255.0.0"},
+		{"route-ipv6", "fd00:1::/64"},
+	}, r.ServerRoutes())
+
+	c := config.NewConfig("", "")
+	r.AddServerRoutes(c)
+	args, err := c.ToArguments()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"--route", "10.1.0.0", "255.255.255.0",
+		"--route", "10.2.0.0", "255.255.0.0",
+		"--route-ipv6", "fd00:1::/64",
+	}, args)
+}
+
+func Test_SitesOnlineStatus(t *testing.T) {
+	var changes []Status
+	r := NewRegistry(func(status Status) {
+		changes = append(changes, status)
+	})
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	require.NoError(t, r.Add(officeB))
+	require.NoError(t, r.Add(officeA))
+
+	consume(t, r,
+		">CLIENT:ESTABLISHED,7", ">CLIENT:ENV,common_name=router-a", ">CLIENT:ENV,END",
+		">CLIENT:ESTABLISHED,8", ">CLIENT:ENV,common_name=laptop", ">CLIENT:ENV,END",
+	)
+	assert.Equal(t, []Status{
+		{Site: officeA, Online: true, ClientID: 7, Since: now},
+		{Site: officeB},
+	}, r.Sites())
+	require.Len(t, changes, 1)
+	assert.True(t, changes[0].Online)
+
+	now = now.Add(time.Hour)
+	consume(t, r, fmt.Sprintf(">CLIENT:DISCONNECT,%d", 8), ">CLIENT:ENV,END")
+	assert.Len(t, changes, 1)
+
+	consume(t, r, fmt.Sprintf(">CLIENT:DISCONNECT,%d", 7), ">CLIENT:ENV,END")
+	require.Len(t, changes, 2)
+	assert.Equal(t, Status{Site: officeA, Since: now}, changes[1])
+	assert.False(t, r.Sites()[0].Online)
+}
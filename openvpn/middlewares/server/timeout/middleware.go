@@ -0,0 +1,192 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package timeout
+
+import (
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+const (
+	sessionLimitMessage = "HALT,session time limit reached"
+	idleTimeoutMessage  = "HALT,disconnected due to inactivity"
+)
+
+// Limits defines how long a client is allowed to stay connected.
+type Limits struct {
+	// MaxDuration is a maximum session length, zero means unlimited.
+	MaxDuration time.Duration
+	// IdleTimeout disconnects clients without traffic for the given time, zero disables it.
+	IdleTimeout time.Duration
+	// IdleThreshold is an amount of bytes per byte count sample which is still considered idle.
+	IdleThreshold uint64
+}
+
+// LimitsProvider callback returns limits of the given user.
+// Returning false applies the default limits.
+type LimitsProvider func(username string) (Limits, bool)
+
+type client struct {
+	username    string
+	limits      Limits
+	bytes       uint64
+	sessionTime *time.Timer
+	idleTime    *time.Timer
+}
+
+func (c *client) stop() {
+	if c.sessionTime != nil {
+		c.sessionTime.Stop()
+	}
+	if c.idleTime != nil {
+		c.idleTime.Stop()
+	}
+}
+
+// Middleware disconnects clients exceeding session duration limits or staying idle for too long.
+//
+// Idle time is measured by session byte counts which should be fed to HandleByteCount,
+// i.e. by the bytecount middleware.
+type Middleware struct {
+	*auth.Middleware
+
+	limits    Limits
+	overrides LimitsProvider
+
+	mu      sync.Mutex
+	clients map[int]*client
+}
+
+// NewMiddleware creates new instance of Middleware.
+// Overrides are optional and may be nil.
+func NewMiddleware(limits Limits, overrides LimitsProvider) *Middleware {
+	m := &Middleware{
+		limits:    limits,
+		overrides: overrides,
+		clients:   make(map[int]*client),
+	}
+	m.Middleware = auth.NewMiddleware(m.handleClientEvent)
+	return m
+}
+
+// Stop stops the middleware.
+func (m *Middleware) Stop(commandWriter management.CommandWriter) error {
+	m.mu.Lock()
+	for clientID, c := range m.clients {
+		c.stop()
+		delete(m.clients, clientID)
+	}
+	m.mu.Unlock()
+
+	return m.Middleware.Stop(commandWriter)
+}
+
+// HandleByteCount restarts idle timer of the client when it transfers enough traffic.
+func (m *Middleware) HandleByteCount(count bytecount.SessionByteCount) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.clients[count.ClientID]
+	if !ok {
+		return
+	}
+
+	total := count.BytesIn + count.BytesOut
+	delta := total - c.bytes
+	if total < c.bytes {
+		delta = total
+	}
+	c.bytes = total
+
+	if c.idleTime != nil && delta > c.limits.IdleThreshold {
+		c.idleTime.Reset(c.limits.IdleTimeout)
+	}
+}
+
+func (m *Middleware) handleClientEvent(event server.ClientEvent) {
+	switch event.EventType {
+	case server.Established:
+		m.track(event.ClientID, event.Env["username"])
+	case server.Disconnect:
+		m.forget(event.ClientID)
+	}
+}
+
+func (m *Middleware) track(clientID int, username string) {
+	limits := m.limits
+	if m.overrides != nil {
+		if userLimits, ok := m.overrides(username); ok {
+			limits = userLimits
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if previous, ok := m.clients[clientID]; ok {
+		previous.stop()
+	}
+
+	c := &client{username: username, limits: limits}
+	if limits.MaxDuration > 0 {
+		c.sessionTime = time.AfterFunc(limits.MaxDuration, func() {
+			m.kill(clientID, c, sessionLimitMessage)
+		})
+	}
+	if limits.IdleTimeout > 0 {
+		c.idleTime = time.AfterFunc(limits.IdleTimeout, func() {
+			m.kill(clientID, c, idleTimeoutMessage)
+		})
+	}
+	m.clients[clientID] = c
+}
+
+func (m *Middleware) forget(clientID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.clients[clientID]; ok {
+		c.stop()
+		delete(m.clients, clientID)
+	}
+}
+
+func (m *Middleware) kill(clientID int, c *client, message string) {
+	m.mu.Lock()
+	if m.clients[clientID] != c {
+		// client is already gone or replaced
+		m.mu.Unlock()
+		return
+	}
+	c.stop()
+	delete(m.clients, clientID)
+	m.mu.Unlock()
+
+	log.Info("Killing client with ID:", clientID, "user:", c.username, "reason:", message)
+	if err := m.ClientKillWithMessage(clientID, message); err != nil {
+		log.Error("Unable to kill client:", err)
+	}
+}
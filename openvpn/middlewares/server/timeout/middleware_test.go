@@ -0,0 +1,116 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package timeout
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/bytecount"
+)
+
+type channelConnection chan string
+
+func (c channelConnection) SingleLineCommand(template string, args ...interface{}) (string, error) {
+	c <- fmt.Sprintf(template, args...)
+	return "", nil
+}
+
+func (c channelConnection) MultiLineCommand(template string, args ...interface{}) (string, []string, error) {
+	_, err := c.SingleLineCommand(template, args...)
+	return "", nil, err
+}
+
+func expectCommand(t *testing.T, commands channelConnection, expected string) {
+	select {
+	case command := <-commands:
+		assert.Equal(t, expected, command)
+	case <-time.After(time.Second):
+		t.Fatal("expected command:", expected)
+	}
+}
+
+func expectNoCommand(t *testing.T, commands channelConnection, wait time.Duration) {
+	select {
+	case command := <-commands:
+		t.Fatal("unexpected command:", command)
+	case <-time.After(wait):
+	}
+}
+
+func established(clientID int, username string) server.ClientEvent {
+	return server.ClientEvent{
+		EventType: server.Established,
+		ClientID:  clientID,
+		Env:       map[string]string{"username": username},
+	}
+}
+
+func Test_SessionDurationLimit(t *testing.T) {
+	commands := make(channelConnection, 10)
+	middleware := NewMiddleware(Limits{MaxDuration: 20 * time.Millisecond}, nil)
+	middleware.Start(commands)
+
+	middleware.handleClientEvent(established(1, "alice"))
+	expectCommand(t, commands, `client-kill 1 "HALT,session time limit reached"`)
+}
+
+func Test_DisconnectedClientIsNotKilled(t *testing.T) {
+	commands := make(channelConnection, 10)
+	middleware := NewMiddleware(Limits{MaxDuration: 20 * time.Millisecond, IdleTimeout: 20 * time.Millisecond}, nil)
+	middleware.Start(commands)
+
+	middleware.handleClientEvent(established(1, "alice"))
+	middleware.handleClientEvent(server.ClientEvent{EventType: server.Disconnect, ClientID: 1})
+	expectNoCommand(t, commands, 50*time.Millisecond)
+}
+
+func Test_IdleTimeoutIsPostponedByTraffic(t *testing.T) {
+	commands := make(channelConnection, 10)
+	middleware := NewMiddleware(Limits{IdleTimeout: 150 * time.Millisecond, IdleThreshold: 100}, nil)
+	middleware.Start(commands)
+
+	middleware.handleClientEvent(established(1, "alice"))
+	for i := 1; i <= 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: uint64(i * 1000)})
+	}
+	expectNoCommand(t, commands, 0)
+
+	// traffic below threshold is considered idle
+	middleware.HandleByteCount(bytecount.SessionByteCount{ClientID: 1, BytesIn: 4050})
+	expectCommand(t, commands, `client-kill 1 "HALT,disconnected due to inactivity"`)
+}
+
+func Test_UserOverrides(t *testing.T) {
+	commands := make(channelConnection, 10)
+	middleware := NewMiddleware(Limits{MaxDuration: 20 * time.Millisecond}, func(username string) (Limits, bool) {
+		return Limits{}, username == "admin"
+	})
+	middleware.Start(commands)
+
+	middleware.handleClientEvent(established(1, "admin"))
+	middleware.handleClientEvent(established(2, "alice"))
+	expectCommand(t, commands, `client-kill 2 "HALT,session time limit reached"`)
+	expectNoCommand(t, commands, 50*time.Millisecond)
+}
@@ -0,0 +1,65 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webauth
+
+import (
+	"net/http"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+)
+
+// CallbackVerifier checks the login callback request of the pending session,
+// i.e. exchanges identity provider authorization code.
+// Returned reason is sent to denied clients.
+type CallbackVerifier func(r *http.Request, session Session) (accepted bool, reason string, err error)
+
+// Handler returns HTTP handler of the login callback. It expects the one-time state in "state" parameter,
+// verifies the request and completes the pending authentication.
+func (m *Middleware) Handler(verify CallbackVerifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := r.FormValue("state")
+		session, ok := m.Pending(state)
+		if !ok {
+			http.Error(w, ErrUnknownState.Error(), http.StatusNotFound)
+			return
+		}
+
+		accepted, reason, err := verify(r, session)
+		if err != nil {
+			log.Error("Web authentication callback failed:", err)
+			accepted, reason = false, ""
+		}
+		if err := m.Complete(state, accepted, reason); err == ErrUnknownState {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Error("Unable to complete web authentication:", err)
+			http.Error(w, "unable to complete authentication", http.StatusBadGateway)
+			return
+		}
+
+		if !accepted {
+			http.Error(w, "Authentication failed.", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Authentication succeeded, you may close this window.\n"))
+	})
+}
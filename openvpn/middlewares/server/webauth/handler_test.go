@@ -0,0 +1,103 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webauth
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HandlerCompletesAuthenticationVerifiedByIdentityProvider(t *testing.T) {
+	// identity provider stand-in, which knows a single valid authorization code
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "valid-code" {
+			http.Error(w, "invalid code", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("alice"))
+	}))
+	defer provider.Close()
+
+	m, commands := newTestMiddleware(time.Minute)
+	defer m.Stop(commands)
+
+	callback := httptest.NewServer(m.Handler(func(r *http.Request, session Session) (bool, string, error) {
+		response, err := http.PostForm(provider.URL, url.Values{"code": {r.FormValue("code")}})
+		if err != nil {
+			return false, "", err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return false, "identity provider rejected login", nil
+		}
+		user, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return false, "", err
+		}
+		if string(user) != session.Info.CommonName {
+			return false, "user does not match certificate", nil
+		}
+		return true, "", nil
+	}))
+	defer callback.Close()
+
+	state := pendingState(t, m, commands, 1, 2)
+	response, err := http.Get(callback.URL + "?code=valid-code&state=" + state)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "client-auth-nt 1 2", expectCommand(t, commands))
+
+	response, err = http.Get(callback.URL + "?code=valid-code&state=" + state)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusNotFound, response.StatusCode, "state is single use")
+
+	state = pendingState(t, m, commands, 3, 0)
+	response, err = http.Get(callback.URL + "?code=stolen&state=" + state)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.Equal(t, `client-deny 3 0 "identity provider rejected login" "identity provider rejected login"`, expectCommand(t, commands))
+}
+
+func Test_HandlerDeniesOnVerifierError(t *testing.T) {
+	m, commands := newTestMiddleware(time.Minute)
+	defer m.Stop(commands)
+
+	handler := m.Handler(func(*http.Request, Session) (bool, string, error) {
+		return false, "", errors.New("provider unavailable")
+	})
+
+	state := pendingState(t, m, commands, 1, 2)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/callback?state="+state, nil))
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Equal(t, `client-deny 1 2 "web authentication failed" "web authentication failed"`, expectCommand(t, commands))
+}
@@ -0,0 +1,253 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/trevor403/go-openvpn-static/openvpn/log"
+	"github.com/trevor403/go-openvpn-static/openvpn/management"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server"
+	"github.com/trevor403/go-openvpn-static/openvpn/middlewares/server/auth"
+)
+
+const (
+	// ssoMethod is the IV_SSO method of clients able to open web authentication URLs.
+	ssoMethod = "webauth"
+
+	unsupportedMessage = "client does not support web authentication"
+	timeoutMessage     = "web authentication timed out"
+	failedMessage      = "web authentication failed"
+)
+
+// ErrUnknownState is returned when completing authentication which is not pending,
+// i.e. it has already been completed, timed out or the state was forged.
+var ErrUnknownState = errors.New("unknown or expired authentication state")
+
+// Config configures web authentication.
+type Config struct {
+	// URL of the login page, one-time state parameter is added to it.
+	URL string
+	// Timeout for the client to finish authentication in the browser.
+	Timeout time.Duration
+}
+
+// Session is a pending web authentication of a client.
+type Session struct {
+	State     string
+	ClientID  int
+	ClientKey int
+	Info      server.ClientInfo
+	Expires   time.Time
+}
+
+type pending struct {
+	session Session
+	timer   *time.Timer
+}
+
+// Middleware authenticates clients in a browser, using OpenVPN 2.5+ pending authentication.
+// Each CONNECT or REAUTH request gets a one-time login URL, the result is delivered
+// by Complete or by the callback handler returned by Handler.
+// Clients which do not finish authentication in time are denied.
+type Middleware struct {
+	*auth.Middleware
+
+	config Config
+	now    func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]*pending
+}
+
+// NewMiddleware creates new instance of Middleware.
+func NewMiddleware(config Config) *Middleware {
+	m := &Middleware{
+		config:  config,
+		now:     time.Now,
+		pending: make(map[string]*pending),
+	}
+	m.Middleware = auth.NewMiddleware(m.handleClientEvent)
+	return m
+}
+
+// Stop stops the middleware, pending authentications are dropped.
+func (m *Middleware) Stop(commandWriter management.CommandWriter) error {
+	m.mu.Lock()
+	for state, p := range m.pending {
+		p.timer.Stop()
+		delete(m.pending, state)
+	}
+	m.mu.Unlock()
+
+	return m.Middleware.Stop(commandWriter)
+}
+
+// Pending returns the pending authentication with given state, i.e. for the login page to show who is logging in.
+func (m *Middleware) Pending(state string) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pending[state]
+	if !ok {
+		return Session{}, false
+	}
+	return p.session, true
+}
+
+// Complete finishes pending authentication with given state by accepting or denying the client.
+// Every state can be completed only once. Empty reason of denied client is replaced with a generic one.
+func (m *Middleware) Complete(state string, accepted bool, reason string) error {
+	p, ok := m.take(state)
+	if !ok {
+		return ErrUnknownState
+	}
+
+	session := p.session
+	if accepted {
+		log.Info("Web authentication of client:", session.ClientID, "succeeded")
+		return m.ClientAccept(session.ClientID, session.ClientKey)
+	}
+
+	if reason == "" {
+		reason = failedMessage
+	}
+	log.Info("Web authentication of client:", session.ClientID, "failed:", reason)
+	return m.ClientDenyWithMessage(session.ClientID, session.ClientKey, reason)
+}
+
+func (m *Middleware) handleClientEvent(event server.ClientEvent) {
+	switch event.EventType {
+	case server.Connect, server.Reauth:
+		if err := m.startAuthentication(event); err != nil {
+			log.Error("Unable to start web authentication:", err)
+		}
+	case server.Disconnect:
+		m.dropClient(event.ClientID)
+	}
+}
+
+func (m *Middleware) startAuthentication(event server.ClientEvent) error {
+	info := event.Info()
+	if !supportsWebAuth(info) {
+		return m.ClientDenyWithMessage(event.ClientID, event.ClientKey, unsupportedMessage)
+	}
+
+	state, err := newState()
+	if err != nil {
+		m.ClientDenyWithMessage(event.ClientID, event.ClientKey, "internal error")
+		return err
+	}
+	loginURL, err := m.loginURL(state)
+	if err != nil {
+		m.ClientDenyWithMessage(event.ClientID, event.ClientKey, "internal error")
+		return err
+	}
+
+	p := &pending{session: Session{
+		State:     state,
+		ClientID:  event.ClientID,
+		ClientKey: event.ClientKey,
+		Info:      info,
+		Expires:   m.now().Add(m.config.Timeout),
+	}}
+	m.mu.Lock()
+	m.pending[state] = p
+	p.timer = time.AfterFunc(m.config.Timeout, func() {
+		m.expire(state)
+	})
+	m.mu.Unlock()
+
+	if err := m.ClientPendingAuth(event.ClientID, event.ClientKey, "WEB_AUTH::"+loginURL, m.config.Timeout); err != nil {
+		m.take(state)
+		return err
+	}
+	return nil
+}
+
+func (m *Middleware) expire(state string) {
+	p, ok := m.take(state)
+	if !ok {
+		return
+	}
+	log.Info("Web authentication of client:", p.session.ClientID, "timed out")
+	if err := m.ClientDenyWithMessage(p.session.ClientID, p.session.ClientKey, timeoutMessage); err != nil {
+		log.Error("Unable to deny client:", err)
+	}
+}
+
+// take removes and returns the pending authentication.
+func (m *Middleware) take(state string) (*pending, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pending[state]
+	if !ok {
+		return nil, false
+	}
+	p.timer.Stop()
+	delete(m.pending, state)
+	return p, true
+}
+
+func (m *Middleware) dropClient(clientID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for state, p := range m.pending {
+		if p.session.ClientID == clientID {
+			p.timer.Stop()
+			delete(m.pending, state)
+		}
+	}
+}
+
+func (m *Middleware) loginURL(state string) (string, error) {
+	u, err := url.Parse(m.config.URL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("state", state)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func supportsWebAuth(info server.ClientInfo) bool {
+	for _, method := range info.SSO {
+		if method == ssoMethod {
+			return true
+		}
+	}
+	return false
+}
+
+func newState() (string, error) {
+	state := make([]byte, 32)
+	if _, err := rand.Read(state); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(state), nil
+}
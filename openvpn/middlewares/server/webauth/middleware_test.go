@@ -0,0 +1,134 @@
+/*
+ * go-openvpn -- Go gettable library for wrapping Openvpn functionality in go way.
+ *
+ * Copyright (C) 2020 BlockDev AG.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License Version 3
+ * as published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program in the COPYING file.
+ * If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webauth
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type channelConnection chan string
+
+func (c channelConnection) SingleLineCommand(template string, args ...interface{}) (string, error) {
+	c <- fmt.Sprintf(template, args...)
+	return "", nil
+}
+
+func (c channelConnection) MultiLineCommand(template string, args ...interface{}) (string, []string, error) {
+	_, err := c.SingleLineCommand(template, args...)
+	return "", nil, err
+}
+
+func expectCommand(t *testing.T, commands channelConnection) string {
+	select {
+	case command := <-commands:
+		return command
+	case <-time.After(time.Second):
+		t.Fatal("command expected")
+	}
+	return ""
+}
+
+func connect(t *testing.T, m *Middleware, clientID, keyID int, sso string) {
+	for _, line := range []string{
+		fmt.Sprintf(">CLIENT:CONNECT,%d,%d", clientID, keyID),
+		">CLIENT:ENV,common_name=alice",
+		">CLIENT:ENV,IV_SSO=" + sso,
+		">CLIENT:ENV,END",
+	} {
+		_, err := m.ConsumeLine(line)
+		require.NoError(t, err, line)
+	}
+}
+
+// pendingState starts pending authentication and returns its state parsed from the login URL.
+func pendingState(t *testing.T, m *Middleware, commands channelConnection, clientID, keyID int) string {
+	connect(t, m, clientID, keyID, "openurl,webauth,crtext")
+	command := expectCommand(t, commands)
+
+	prefix := fmt.Sprintf(`client-pending-auth %d %d "WEB_AUTH::`, clientID, keyID)
+	require.True(t, strings.HasPrefix(command, prefix), command)
+	loginURL, err := url.Parse(strings.Split(strings.TrimPrefix(command, prefix), `"`)[0])
+	require.NoError(t, err)
+	assert.Equal(t, "login.example.com", loginURL.Host)
+	assert.Equal(t, "en", loginURL.Query().Get("lang"))
+	return loginURL.Query().Get("state")
+}
+
+func newTestMiddleware(timeout time.Duration) (*Middleware, channelConnection) {
+	commands := make(channelConnection, 10)
+	m := NewMiddleware(Config{URL: "https://login.example.com/vpn?lang=en", Timeout: timeout})
+	m.Start(commands)
+	return m, commands
+}
+
+func Test_CompleteAcceptsOrDeniesPendingClient(t *testing.T) {
+	m, commands := newTestMiddleware(time.Minute)
+	defer m.Stop(commands)
+
+	state := pendingState(t, m, commands, 1, 2)
+	session, ok := m.Pending(state)
+	require.True(t, ok)
+	assert.Equal(t, "alice", session.Info.CommonName)
+
+	assert.NoError(t, m.Complete(state, true, ""))
+	assert.Equal(t, "client-auth-nt 1 2", expectCommand(t, commands))
+	assert.Equal(t, ErrUnknownState, m.Complete(state, true, ""), "state is single use")
+
+	state = pendingState(t, m, commands, 3, 0)
+	assert.NoError(t, m.Complete(state, false, ""))
+	assert.Equal(t, `client-deny 3 0 "web authentication failed" "web authentication failed"`, expectCommand(t, commands))
+}
+
+func Test_PendingAuthenticationTimesOut(t *testing.T) {
+	m, commands := newTestMiddleware(50 * time.Millisecond)
+	defer m.Stop(commands)
+
+	state := pendingState(t, m, commands, 1, 2)
+	assert.Equal(t, `client-deny 1 2 "web authentication timed out" "web authentication timed out"`, expectCommand(t, commands))
+	assert.Equal(t, ErrUnknownState, m.Complete(state, true, ""))
+}
+
+func Test_ClientWithoutWebAuthSupportIsDenied(t *testing.T) {
+	m, commands := newTestMiddleware(time.Minute)
+	defer m.Stop(commands)
+
+	connect(t, m, 1, 2, "crtext")
+	assert.Equal(t, `client-deny 1 2 "client does not support web authentication" "client does not support web authentication"`, expectCommand(t, commands))
+}
+
+func Test_DisconnectDropsPendingAuthentication(t *testing.T) {
+	m, commands := newTestMiddleware(time.Minute)
+	defer m.Stop(commands)
+
+	state := pendingState(t, m, commands, 1, 2)
+	for _, line := range []string{">CLIENT:DISCONNECT,1", ">CLIENT:ENV,END"} {
+		_, err := m.ConsumeLine(line)
+		require.NoError(t, err)
+	}
+	_, ok := m.Pending(state)
+	assert.False(t, ok)
+}